@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Client is a token allowed to access the repository. Append-only clients
+// can add objects but never overwrite or delete them, except their own locks
+// and checkpoints.
+type Client struct {
+	Name       string `json:"name"`
+	Token      string `json:"token"`
+	AppendOnly bool   `json:"append_only"`
+}
+
+type clientKey struct{}
+
+// LoadClients reads a JSON list of clients from file.
+func LoadClients(file string) ([]Client, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []Client
+
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, err
+	}
+
+	for _, client := range clients {
+		if len(client.Name) == 0 || len(client.Token) == 0 {
+			return nil, errors.New("client name and token are required")
+		}
+	}
+
+	return clients, nil
+}
+
+func (server *Server) authenticate(r *http.Request) (*Client, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(token) == 0 {
+		return nil, false
+	}
+
+	for i := range server.clients {
+		client := &server.clients[i]
+
+		if subtle.ConstantTimeCompare([]byte(client.Token), []byte(token)) == 1 {
+			return client, true
+		}
+	}
+
+	return nil, false
+}
+
+func (server *Server) withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := server.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+	})
+}
+
+func requestClient(r *http.Request) *Client {
+	return r.Context().Value(clientKey{}).(*Client)
+}
@@ -0,0 +1,393 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/vitalvas/backup-server/storage-test/backend"
+)
+
+const (
+	// largest accepted object, packs are around 16MB
+	maxObjectSize = 128 << 20
+
+	maxExistsNames = 100000
+
+	// index files of append-only clients must be named by a recent xid
+	maxIndexAge = 15 * time.Minute
+)
+
+// Server exposes a repository backend over HTTP. The repository content is
+// encrypted by the clients, the server only stores opaque objects:
+//
+//	GET/HEAD/PUT/DELETE /v1/objects/<name>
+//	GET /v1/list/<prefix>
+//	POST /v1/exists {"names": [...]}
+//
+// A PUT with "If-None-Match: *" only creates objects that do not exist.
+type Server struct {
+	backend    backend.Backend
+	clients    []Client
+	appendOnly bool
+	handler    http.Handler
+
+	// owners maps the locks and checkpoints to the client that wrote them
+	// last, it is not persisted
+	mu     sync.Mutex
+	owners map[string]*Client
+}
+
+type Config struct {
+	Backend backend.Backend
+	Clients []Client
+	// AppendOnly forbids overwriting and deleting objects for all clients,
+	// except the locks and checkpoints a client wrote itself.
+	AppendOnly bool
+}
+
+type object struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+type existsRequest struct {
+	Names []string `json:"names"`
+}
+
+func New(conf Config) *Server {
+	server := &Server{
+		backend:    conf.Backend,
+		clients:    conf.Clients,
+		appendOnly: conf.AppendOnly,
+		owners:     make(map[string]*Client),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/objects/", server.handleObject)
+	mux.HandleFunc("/v1/list/", server.handleList)
+	mux.HandleFunc("/v1/exists", server.handleExists)
+
+	server.handler = server.withAuth(mux)
+
+	return server
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.handler.ServeHTTP(w, r)
+}
+
+// objectName validates a slash separated name relative to the repository root.
+func objectName(name string) (string, error) {
+	if len(name) == 0 || path.Clean(name) != name || path.IsAbs(name) ||
+		name == ".." || strings.HasPrefix(name, "../") || strings.HasSuffix(name, ".tmp") {
+		return "", fmt.Errorf("invalid object name: %q", name)
+	}
+
+	return name, nil
+}
+
+// isOwned reports whether the object is rewritten and removed by the client
+// that wrote it, like the locks and checkpoints of a running backup.
+func isOwned(name string) bool {
+	return strings.HasPrefix(name, "locks/") || strings.HasPrefix(name, "checkpoints/")
+}
+
+// isBackdated reports whether an index file is named older than it can be.
+// Clients load the index files in name order and keep the first entry of a
+// chunk, a backdated file of an append-only client would move known chunks.
+func isBackdated(name string) bool {
+	if !strings.HasPrefix(name, "index/") {
+		return false
+	}
+
+	id, err := xid.FromString(strings.TrimSuffix(path.Base(name), ".idx"))
+	if err != nil {
+		return true
+	}
+
+	return time.Since(id.Time()) > maxIndexAge
+}
+
+// canModify reports whether the client may overwrite or delete an existing
+// object. Append-only clients may only change the locks and checkpoints
+// they wrote since the server started.
+func (server *Server) canModify(r *http.Request, name string) bool {
+	client := requestClient(r)
+
+	if !server.appendOnly && !client.AppendOnly {
+		return true
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return isOwned(name) && server.owners[name] == client
+}
+
+// setOwner records the client that wrote an owned object, nil forgets it.
+func (server *Server) setOwner(name string, client *Client) {
+	if !isOwned(name) {
+		return
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if client == nil {
+		delete(server.owners, name)
+		return
+	}
+
+	server.owners[name] = client
+}
+
+func (server *Server) sendError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, backend.ErrNotExist) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("%s %s %s: %s", requestClient(r).Name, r.Method, r.URL.Path, err)
+
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+func (server *Server) sendJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Print(err)
+	}
+}
+
+func (server *Server) handleObject(w http.ResponseWriter, r *http.Request) {
+	name, err := objectName(strings.TrimPrefix(r.URL.Path, "/v1/objects/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		info, err := server.backend.Stat(name)
+		if err != nil {
+			server.sendError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+
+	case http.MethodGet:
+		server.getObject(w, r, name)
+
+	case http.MethodPut:
+		server.putObject(w, r, name)
+
+	case http.MethodDelete:
+		if !server.canModify(r, name) {
+			log.Printf("%s: delete of %s denied in append-only mode", requestClient(r).Name, name)
+			http.Error(w, "append-only mode", http.StatusForbidden)
+
+			return
+		}
+
+		if err := server.backend.Delete(name); err != nil {
+			server.sendError(w, r, err)
+			return
+		}
+
+		server.setOwner(name, nil)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// parseRange accepts a single "bytes=start-end" range.
+func parseRange(header string) (int64, int64, error) {
+	var start, end int64
+
+	if _, err := fmt.Sscanf(header, "bytes=%d-%d", &start, &end); err != nil {
+		return 0, 0, err
+	}
+
+	if start < 0 || end < start {
+		return 0, 0, fmt.Errorf("invalid range: %q", header)
+	}
+
+	return start, end - start + 1, nil
+}
+
+func (server *Server) getObject(w http.ResponseWriter, r *http.Request, name string) {
+	header := r.Header.Get("Range")
+
+	if len(header) == 0 {
+		data, err := server.backend.Get(name)
+		if err != nil {
+			server.sendError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+
+		return
+	}
+
+	offset, length, err := parseRange(header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	data, err := server.backend.GetRange(name, offset, length)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			http.Error(w, "range out of object", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+
+		server.sendError(w, r, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, offset+length-1))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(data)
+}
+
+func (server *Server) putObject(w http.ResponseWriter, r *http.Request, name string) {
+	canModify := server.canModify(r, name)
+
+	if !canModify && isBackdated(name) {
+		log.Printf("%s: backdated index %s denied in append-only mode", requestClient(r).Name, name)
+		http.Error(w, "append-only mode", http.StatusForbidden)
+
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxObjectSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// objects that may not be overwritten are created in a single step, so
+	// a concurrent upload of the same name cannot slip in between
+	if canModify && r.Header.Get("If-None-Match") != "*" {
+		err = server.backend.Put(name, data)
+	} else {
+		err = server.backend.Create(name, data)
+	}
+
+	if errors.Is(err, backend.ErrExist) {
+		if !canModify {
+			log.Printf("%s: overwrite of %s denied in append-only mode", requestClient(r).Name, name)
+			http.Error(w, "append-only mode", http.StatusForbidden)
+
+			return
+		}
+
+		http.Error(w, "object exists", http.StatusPreconditionFailed)
+
+		return
+	}
+
+	if err != nil {
+		server.sendError(w, r, err)
+		return
+	}
+
+	server.setOwner(name, requestClient(r))
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (server *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefix := strings.TrimPrefix(r.URL.Path, "/v1/list/")
+
+	if len(prefix) > 0 {
+		var err error
+
+		if prefix, err = objectName(prefix); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	files, err := server.backend.List(prefix)
+	if err != nil {
+		server.sendError(w, r, err)
+		return
+	}
+
+	objects := make([]object, 0, len(files))
+	for _, file := range files {
+		objects = append(objects, object(file))
+	}
+
+	server.sendJSON(w, objects)
+}
+
+// handleExists answers which of the requested objects are stored, so a
+// client can skip uploading many chunks with a single request.
+func (server *Server) handleExists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req existsRequest
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxObjectSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Names) > maxExistsNames {
+		http.Error(w, "too many names", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	for _, name := range req.Names {
+		if _, err := objectName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	exists, err := backend.Exists(server.backend, req.Names)
+	if err != nil {
+		server.sendError(w, r, err)
+		return
+	}
+
+	found := existsRequest{Names: []string{}}
+
+	for _, name := range req.Names {
+		if exists[name] {
+			found.Names = append(found.Names, name)
+		}
+	}
+
+	server.sendJSON(w, found)
+}
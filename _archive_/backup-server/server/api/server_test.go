@@ -0,0 +1,264 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/vitalvas/backup-server/storage-test/backend"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newTestServer(t *testing.T, appendOnly bool) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(New(Config{
+		Backend: backend.NewLocal(t.TempDir()),
+		Clients: []Client{
+			{Name: "admin", Token: "admin-token"},
+			{Name: "alice", Token: "alice-token", AppendOnly: true},
+			{Name: "bob", Token: "bob-token", AppendOnly: !appendOnly},
+		},
+		AppendOnly: appendOnly,
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, token string) *backend.HTTP {
+	t.Helper()
+
+	uri, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := backend.NewHTTP(uri, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestAuth(t *testing.T) {
+	server := newTestServer(t, false)
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"valid token", "Bearer admin-token", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/list/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(test.header) > 0 {
+				req.Header.Set("Authorization", test.header)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp.Body.Close()
+
+			if resp.StatusCode != test.status {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, test.status)
+			}
+		})
+	}
+}
+
+func TestAppendOnly(t *testing.T) {
+	for _, serverAppendOnly := range []bool{false, true} {
+		server := newTestServer(t, serverAppendOnly)
+
+		admin := newTestClient(t, server, "admin-token")
+		alice := newTestClient(t, server, "alice-token")
+		bob := newTestClient(t, server, "bob-token")
+
+		if serverAppendOnly {
+			// every client is append-only, admin included
+			bob = admin
+		}
+
+		steps := []struct {
+			name   string
+			client *backend.HTTP
+			op     func(store *backend.HTTP) error
+			ok     bool
+		}{
+			{"add block", alice, put("blocks/a"), true},
+			{"overwrite block", alice, put("blocks/a"), false},
+			{"delete block", alice, del("blocks/a"), false},
+			{"other client overwrites block", bob, put("blocks/a"), false},
+			{"add lock", alice, put("locks/a"), true},
+			{"refresh own lock", alice, put("locks/a"), true},
+			{"other client overwrites lock", bob, put("locks/a"), false},
+			{"other client deletes lock", bob, del("locks/a"), false},
+			{"delete own lock", alice, del("locks/a"), true},
+			{"add checkpoint", alice, put("checkpoints/a"), true},
+			{"other client deletes checkpoint", bob, del("checkpoints/a"), false},
+			{"delete own checkpoint", alice, del("checkpoints/a"), true},
+			{"add foreign lock", bob, put("locks/b"), true},
+			{"delete foreign lock", alice, del("locks/b"), false},
+			{"full client deletes block", admin, del("blocks/a"), !serverAppendOnly},
+			{"add index", alice, put("index/" + xid.New().String() + ".idx"), true},
+			{"add backdated index", alice, put("index/" + xid.NewWithTime(time.Now().Add(-time.Hour)).String() + ".idx"), false},
+			{"add index without xid", alice, put("index/a.idx"), false},
+		}
+
+		for _, step := range steps {
+			err := step.op(step.client)
+			if step.ok && err != nil {
+				t.Fatalf("server append-only %v, %s: %v", serverAppendOnly, step.name, err)
+			}
+
+			if !step.ok && err == nil {
+				t.Fatalf("server append-only %v, %s: allowed", serverAppendOnly, step.name)
+			}
+		}
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	server := newTestServer(t, false)
+
+	admin := newTestClient(t, server, "admin-token")
+	alice := newTestClient(t, server, "alice-token")
+
+	// append-only uploads and creates of full clients race for the name
+	clients := []*backend.HTTP{alice, alice, alice, admin, admin, admin}
+
+	var wg sync.WaitGroup
+
+	errs := make([]error, len(clients))
+
+	for i, client := range clients {
+		wg.Add(1)
+
+		go func(i int, client *backend.HTTP) {
+			defer wg.Done()
+
+			if client == alice {
+				errs[i] = client.Put("blocks/a", []byte{byte(i)})
+			} else {
+				errs[i] = client.Create("blocks/a", []byte{byte(i)})
+			}
+		}(i, client)
+	}
+
+	wg.Wait()
+
+	created := -1
+
+	for i, err := range errs {
+		if err == nil {
+			if created >= 0 {
+				t.Fatalf("uploads %d and %d both created the object", created, i)
+			}
+
+			created = i
+		}
+	}
+
+	if created < 0 {
+		t.Fatal("no upload created the object")
+	}
+
+	data, err := admin.Get("blocks/a")
+	if err != nil || !bytes.Equal(data, []byte{byte(created)}) {
+		t.Fatalf("got %v, %v, want the content of upload %d", data, err, created)
+	}
+
+	if err := admin.Create("blocks/a", nil); !errors.Is(err, backend.ErrExist) {
+		t.Fatalf("got %v, want ErrExist", err)
+	}
+}
+
+func put(name string) func(store *backend.HTTP) error {
+	return func(store *backend.HTTP) error {
+		return store.Put(name, []byte(name))
+	}
+}
+
+func del(name string) func(store *backend.HTTP) error {
+	return func(store *backend.HTTP) error {
+		return store.Delete(name)
+	}
+}
+
+func TestAppendOnlyBackup(t *testing.T) {
+	server := newTestServer(t, true)
+
+	conf := storage.StorageConfig{
+		Path:     server.URL,
+		Token:    "alice-token",
+		Password: "password",
+		Create:   true,
+		Lock:     storage.LockShared,
+		Chunker:  storage.ChunkerParams{MinSize: 4 << 10, AvgSize: 16 << 10, MaxSize: 64 << 10},
+	}
+
+	store, err := storage.New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("append-only backup "), 1<<14)
+
+	stats, err := store.Writer(bytes.NewReader(data), storage.WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Create = false
+
+	store, err = storage.New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	block, err := store.GetBlock(stats.BlockID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := store.RestoreStream(&buf, block.Blobs, block.CheckSum, storage.RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("restored data differs")
+	}
+
+	if err := store.DeleteBlock(block.ID); err == nil {
+		t.Fatal("append-only client deleted a block")
+	}
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/server/api"
+	"github.com/vitalvas/backup-server/storage-test/backend"
+)
+
+func Execute() {
+	cliApp := &cli.App{
+		Name:  "backup-server",
+		Usage: "serve a repository over http",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "storage-path",
+				Value: "./test",
+			},
+			&cli.StringFlag{
+				Name:  "listen",
+				Value: ":8000",
+				Usage: "address to listen on",
+			},
+			&cli.StringFlag{
+				Name:     "clients",
+				Usage:    "json file with the client names and tokens",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "append-only",
+				Value: false,
+				Usage: "forbid overwriting and deleting objects for every client",
+			},
+			&cli.StringFlag{
+				Name:  "tls-cert",
+				Usage: "tls certificate file",
+			},
+			&cli.StringFlag{
+				Name:  "tls-key",
+				Usage: "tls key file",
+			},
+		},
+		Action: serve,
+	}
+
+	if err := cliApp.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve(c *cli.Context) error {
+	clients, err := api.LoadClients(c.String("clients"))
+	if err != nil {
+		return err
+	}
+
+	if len(clients) == 0 {
+		return errors.New("no clients configured")
+	}
+
+	store, err := backend.Open(c.String("storage-path"), "")
+	if err != nil {
+		return err
+	}
+
+	defer store.Close()
+
+	server := &http.Server{
+		Addr: c.String("listen"),
+		Handler: api.New(api.Config{
+			Backend:    store,
+			Clients:    clients,
+			AppendOnly: c.Bool("append-only"),
+		}),
+		ReadHeaderTimeout: time.Minute,
+		// uploads are up to 128MB, the clients give up after 10 minutes
+		ReadTimeout:  10 * time.Minute,
+		WriteTimeout: 10 * time.Minute,
+		IdleTimeout:  2 * time.Minute,
+	}
+
+	log.Printf("listening on %s", server.Addr)
+
+	if len(c.String("tls-cert")) > 0 {
+		return server.ListenAndServeTLS(c.String("tls-cert"), c.String("tls-key"))
+	}
+
+	return server.ListenAndServe()
+}
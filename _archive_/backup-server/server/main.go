@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/vitalvas/backup-server/server/cmd"
+)
+
+func main() {
+	cmd.Execute()
+}
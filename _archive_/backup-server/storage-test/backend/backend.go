@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrNotExist = errors.New("object does not exist")
+	ErrExist    = errors.New("object already exists")
+)
+
+type FileInfo struct {
+	Name string
+	Size int64
+}
+
+// Backend stores named objects. Names are slash separated paths relative
+// to the repository root, e.g. "blocks/dba0/dba0....dat".
+type Backend interface {
+	Put(name string, data []byte) error
+	// Create stores the object only if the name is not taken, atomically,
+	// and returns ErrExist otherwise.
+	Create(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	GetRange(name string, offset, length int64) ([]byte, error)
+	Stat(name string) (FileInfo, error)
+	// List returns all objects below prefix, recursively.
+	List(prefix string) ([]FileInfo, error)
+	Delete(name string) error
+	Close() error
+}
+
+// Exister is implemented by backends that can check many objects at once.
+type Exister interface {
+	Exists(names []string) (map[string]bool, error)
+}
+
+// Exists reports which of names are stored in the backend, with a single
+// request when the backend supports it and one Stat per name otherwise.
+func Exists(store Backend, names []string) (map[string]bool, error) {
+	if exister, ok := store.(Exister); ok {
+		return exister.Exists(names)
+	}
+
+	exists := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		_, err := store.Stat(name)
+		if err != nil && !errors.Is(err, ErrNotExist) {
+			return nil, err
+		}
+
+		exists[name] = err == nil
+	}
+
+	return exists, nil
+}
+
+// Open returns the backend for a repository location. Plain paths and
+// file:// URLs use the local filesystem, s3://host/bucket/prefix (or
+// s3+http:// for plain http) an S3-compatible object store and
+// sftp://user@host:port/path a remote directory over SFTP and
+// http(s)://host/ a backup server. Token is the bearer token of a backup
+// server, it is kept out of the location so it never shows up in errors.
+func Open(location, token string) (Backend, error) {
+	if !strings.Contains(location, "://") {
+		return NewLocal(location), nil
+	}
+
+	uri, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	switch uri.Scheme {
+	case "file":
+		return NewLocal(uri.Path), nil
+
+	case "s3", "s3+https":
+		return NewS3(uri, true)
+
+	case "s3+http":
+		return NewS3(uri, false)
+
+	case "sftp":
+		return NewSFTP(uri)
+
+	case "http", "https":
+		return NewHTTP(uri, token)
+
+	default:
+		return nil, fmt.Errorf("unsupported storage scheme: %s", uri.Scheme)
+	}
+}
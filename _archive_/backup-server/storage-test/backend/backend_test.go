@@ -0,0 +1,426 @@
+package backend_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/pkg/sftp"
+	"github.com/vitalvas/backup-server/server/api"
+	"github.com/vitalvas/backup-server/storage-test/backend"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testBackends returns every backend that runs without external services,
+// the http backend talks to the backup server on an httptest listener, s3
+// and sftp to in-process fakes.
+func testBackends(t *testing.T) map[string]backend.Backend {
+	t.Helper()
+
+	server := httptest.NewServer(api.New(api.Config{
+		Backend: backend.NewLocal(t.TempDir()),
+		Clients: []api.Client{{Name: "test", Token: "token"}},
+	}))
+
+	t.Cleanup(server.Close)
+
+	uri, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := backend.NewHTTP(uri, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := backend.Open(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]backend.Backend{
+		"local": local,
+		"http":  remote,
+		"s3":    newTestS3(t),
+		"sftp":  newTestSFTP(t),
+	}
+}
+
+// newTestS3 opens a bucket of an in-memory S3 fake. The fake has no
+// conditional writes, they are checked in front of it.
+func newTestS3(t *testing.T) backend.Backend {
+	t.Helper()
+
+	store := s3mem.New()
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := gofakes3.New(store).Server()
+
+	var mu sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.Header.Get("If-None-Match") == "*" {
+			mu.Lock()
+			defer mu.Unlock()
+
+			parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+
+			if _, err := store.HeadObject(parts[0], parts[1]); err == nil {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				fmt.Fprint(w, "<Error><Code>PreconditionFailed</Code><Message>object exists</Message></Error>")
+
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	uri, err := url.Parse(strings.Replace(server.URL, "http://", "s3+http://key:secret@", 1) + "/bucket/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := backend.NewS3(uri, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return remote
+}
+
+// newTestSFTP serves an in-memory filesystem over SFTP on a local ssh
+// server, the host key is trusted through a known_hosts file in a new home.
+func newTestSFTP(t *testing.T) backend.Backend {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() != "test" || string(password) != "secret" {
+				return nil, errors.New("access denied")
+			}
+
+			return nil, nil
+		},
+	}
+
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveTestSFTP(conn, config)
+		}
+	}()
+
+	home := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	knownHosts := knownhosts.Line([]string{listener.Addr().String()}, hostKey.PublicKey())
+
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(knownHosts+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	uri, err := url.Parse("sftp://test:secret@" + listener.Addr().String() + "/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := backend.NewSFTP(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return remote
+}
+
+func serveTestSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+
+	// a single filesystem for all sessions of the connection
+	handlers := sftp.InMemHandler()
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range channelRequests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+
+				if ok {
+					go func() {
+						defer channel.Close()
+
+						sftp.NewRequestServer(channel, handlers).Serve()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+func TestBackends(t *testing.T) {
+	for name, store := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			objects := map[string]string{
+				"config":               "config",
+				"blocks/dba0/a.dat":    "block a",
+				"blocks/dba1/b.dat":    "block b",
+				"packs/dba0/pack.pack": "0123456789",
+			}
+
+			for name, data := range objects {
+				if err := store.Put(name, []byte(data)); err != nil {
+					t.Fatalf("put %s: %v", name, err)
+				}
+			}
+
+			tests := []struct {
+				name string
+				run  func() (interface{}, error)
+				want interface{}
+				err  error
+			}{
+				{
+					name: "get",
+					run:  func() (interface{}, error) { return getString(store.Get("blocks/dba0/a.dat")) },
+					want: "block a",
+				},
+				{
+					name: "get missing",
+					run:  func() (interface{}, error) { return getString(store.Get("blocks/dba0/missing.dat")) },
+					err:  backend.ErrNotExist,
+				},
+				{
+					name: "get range",
+					run:  func() (interface{}, error) { return getString(store.GetRange("packs/dba0/pack.pack", 3, 4)) },
+					want: "3456",
+				},
+				{
+					name: "get range to end",
+					run:  func() (interface{}, error) { return getString(store.GetRange("packs/dba0/pack.pack", 8, 2)) },
+					want: "89",
+				},
+				{
+					name: "get range beyond end",
+					run:  func() (interface{}, error) { return getString(store.GetRange("packs/dba0/pack.pack", 8, 4)) },
+					err:  io.ErrUnexpectedEOF,
+				},
+				{
+					name: "get range missing",
+					run:  func() (interface{}, error) { return getString(store.GetRange("packs/dba0/missing.pack", 0, 1)) },
+					err:  backend.ErrNotExist,
+				},
+				{
+					name: "stat",
+					run:  func() (interface{}, error) { return store.Stat("blocks/dba1/b.dat") },
+					want: backend.FileInfo{Name: "blocks/dba1/b.dat", Size: 7},
+				},
+				{
+					name: "stat missing",
+					run:  func() (interface{}, error) { return store.Stat("blocks/dba1/missing.dat") },
+					err:  backend.ErrNotExist,
+				},
+				{
+					name: "list prefix",
+					run:  func() (interface{}, error) { return listNames(store.List("blocks")) },
+					want: []string{"blocks/dba0/a.dat", "blocks/dba1/b.dat"},
+				},
+				{
+					name: "list all",
+					run:  func() (interface{}, error) { return listNames(store.List("")) },
+					want: []string{"blocks/dba0/a.dat", "blocks/dba1/b.dat", "config", "packs/dba0/pack.pack"},
+				},
+				{
+					name: "list missing prefix",
+					run:  func() (interface{}, error) { return listNames(store.List("snapshots")) },
+					want: []string{},
+				},
+				{
+					name: "exists",
+					run: func() (interface{}, error) {
+						return backend.Exists(store, []string{"config", "blocks/dba0/missing.dat"})
+					},
+					want: map[string]bool{"config": true, "blocks/dba0/missing.dat": false},
+				},
+				{
+					name: "delete missing",
+					run:  func() (interface{}, error) { return nil, store.Delete("blocks/dba0/missing.dat") },
+					err:  backend.ErrNotExist,
+				},
+			}
+
+			for _, test := range tests {
+				got, err := test.run()
+
+				if test.err != nil {
+					if !errors.Is(err, test.err) {
+						t.Fatalf("%s: got error %v, want %v", test.name, err, test.err)
+					}
+
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("%s: %v", test.name, err)
+				}
+
+				if !reflect.DeepEqual(got, test.want) {
+					t.Fatalf("%s: got %v, want %v", test.name, got, test.want)
+				}
+			}
+
+			// create only adds objects and leaves no temporary files
+			if err := store.Create("config", []byte("other config")); !errors.Is(err, backend.ErrExist) {
+				t.Fatalf("create existing: %v", err)
+			}
+
+			if err := store.Create("snapshots/a", []byte("snapshot")); err != nil {
+				t.Fatal(err)
+			}
+
+			if data, err := store.Get("snapshots/a"); err != nil || string(data) != "snapshot" {
+				t.Fatalf("get after create: %q, %v", data, err)
+			}
+
+			if names, err := listNames(store.List("")); err != nil || len(names.([]string)) != len(objects)+1 {
+				t.Fatalf("list after create: %v, %v", names, err)
+			}
+
+			// overwrite, then delete
+			if err := store.Put("config", []byte("new config")); err != nil {
+				t.Fatal(err)
+			}
+
+			if data, err := store.Get("config"); err != nil || string(data) != "new config" {
+				t.Fatalf("get after overwrite: %q, %v", data, err)
+			}
+
+			if err := store.Delete("config"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.Get("config"); !errors.Is(err, backend.ErrNotExist) {
+				t.Fatalf("get after delete: %v", err)
+			}
+		})
+	}
+}
+
+func getString(data []byte, err error) (interface{}, error) {
+	return string(data), err
+}
+
+func listNames(files []backend.FileInfo, err error) (interface{}, error) {
+	names := []string{}
+
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+
+	sort.Strings(names)
+
+	return names, err
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		location string
+		want     interface{}
+		err      bool
+	}{
+		{location: "./repo", want: &backend.Local{}},
+		{location: "file:///tmp/repo", want: &backend.Local{}},
+		{location: "https://backup.example.com/", want: &backend.HTTP{}},
+		{location: "s3://s3.example.com/bucket/prefix", want: &backend.S3{}},
+		{location: "s3://s3.example.com/", err: true},
+		{location: "ftp://example.com/repo", err: true},
+	}
+
+	for _, test := range tests {
+		store, err := backend.Open(test.location, "")
+		if test.err {
+			if err == nil {
+				t.Fatalf("%s: opened", test.location)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.location, err)
+		}
+
+		if reflect.TypeOf(store) != reflect.TypeOf(test.want) {
+			t.Fatalf("%s: got %T, want %T", test.location, store, test.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTP talks to the backup server REST API. Every object name maps to
+// /v1/objects/<name>, listing and batch existence checks have their own
+// endpoints.
+type HTTP struct {
+	client *http.Client
+	base   string
+	token  string
+}
+
+// NewHTTP connects to a backup server at http(s)://host/path, the token is
+// sent as a bearer token. Without a token the url user info is used.
+func NewHTTP(uri *url.URL, token string) (*HTTP, error) {
+	base := *uri
+
+	if base.User != nil {
+		if len(token) == 0 {
+			token = base.User.Username()
+		}
+
+		base.User = nil
+	}
+
+	return &HTTP{
+		client: &http.Client{Timeout: 10 * time.Minute},
+		base:   strings.TrimSuffix(base.String(), "/"),
+		token:  token,
+	}, nil
+}
+
+type httpObject struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+type httpExists struct {
+	Names []string `json:"names"`
+}
+
+func (store *HTTP) do(method, endpoint string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, store.base+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	if len(store.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+store.token)
+	}
+
+	resp, err := store.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotExist
+	}
+
+	// the object of a conditional Put exists
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		resp.Body.Close()
+		return nil, ErrExist
+	}
+
+	// like a short read of the other backends
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if len(bytes.TrimSpace(msg)) == 0 {
+			return nil, fmt.Errorf("%s %s: %s", method, endpoint, resp.Status)
+		}
+
+		return nil, fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return resp, nil
+}
+
+func objectEndpoint(name string) string {
+	return "/v1/objects/" + (&url.URL{Path: name}).EscapedPath()
+}
+
+func (store *HTTP) Put(name string, data []byte) error {
+	resp, err := store.do(http.MethodPut, objectEndpoint(name), data, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (store *HTTP) Create(name string, data []byte) error {
+	resp, err := store.do(http.MethodPut, objectEndpoint(name), data, http.Header{"If-None-Match": {"*"}})
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (store *HTTP) Get(name string) ([]byte, error) {
+	resp, err := store.do(http.MethodGet, objectEndpoint(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (store *HTTP) GetRange(name string, offset, length int64) ([]byte, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	resp, err := store.do(http.MethodGet, objectEndpoint(name), nil, header)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("range request for %s: %s", name, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) != length {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return data, nil
+}
+
+func (store *HTTP) Stat(name string) (FileInfo, error) {
+	resp, err := store.do(http.MethodHead, objectEndpoint(name), nil, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	resp.Body.Close()
+
+	return FileInfo{
+		Name: name,
+		Size: resp.ContentLength,
+	}, nil
+}
+
+func (store *HTTP) List(prefix string) ([]FileInfo, error) {
+	resp, err := store.do(http.MethodGet, "/v1/list/"+(&url.URL{Path: prefix}).EscapedPath(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var objects []httpObject
+
+	if err := json.NewDecoder(resp.Body).Decode(&objects); err != nil {
+		return nil, err
+	}
+
+	files := make([]FileInfo, 0, len(objects))
+	for _, object := range objects {
+		files = append(files, FileInfo(object))
+	}
+
+	return files, nil
+}
+
+func (store *HTTP) Exists(names []string) (map[string]bool, error) {
+	body, err := json.Marshal(httpExists{Names: names})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := store.do(http.MethodPost, "/v1/exists", body, http.Header{"Content-Type": {"application/json"}})
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var found httpExists
+
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return nil, err
+	}
+
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		exists[name] = false
+	}
+
+	for _, name := range found.Names {
+		exists[name] = true
+	}
+
+	return exists, nil
+}
+
+func (store *HTTP) Delete(name string) error {
+	resp, err := store.do(http.MethodDelete, objectEndpoint(name), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (store *HTTP) Close() error {
+	store.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,181 @@
+package backend
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type Local struct {
+	path string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{
+		path: root,
+	}
+}
+
+func (local *Local) filePath(name string) string {
+	return filepath.Join(local.path, filepath.FromSlash(name))
+}
+
+func (local *Local) Put(name string, data []byte) error {
+	filePath := local.filePath(name)
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filePath+".tmp", data, 0640); err != nil {
+		return err
+	}
+
+	return os.Rename(filePath+".tmp", filePath)
+}
+
+func (local *Local) Create(name string, data []byte) error {
+	filePath := local.filePath(name)
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	// a private temporary file, concurrent creates do not share it
+	file, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Chmod(0640); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// unlike a rename, a link never replaces an existing file
+	if err := os.Link(file.Name(), filePath); err != nil {
+		if os.IsExist(err) {
+			return ErrExist
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (local *Local) Get(name string) ([]byte, error) {
+	data, err := os.ReadFile(local.filePath(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+
+	return data, err
+}
+
+func (local *Local) GetRange(name string, offset, length int64) ([]byte, error) {
+	file, err := os.Open(local.filePath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+
+		return nil, err
+	}
+
+	defer file.Close()
+
+	data := make([]byte, length)
+
+	if _, err := file.ReadAt(data, offset); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (local *Local) Stat(name string) (FileInfo, error) {
+	info, err := os.Stat(local.filePath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return FileInfo{}, ErrNotExist
+		}
+
+		return FileInfo{}, err
+	}
+
+	return FileInfo{
+		Name: name,
+		Size: info.Size(),
+	}, nil
+}
+
+func (local *Local) List(prefix string) ([]FileInfo, error) {
+	var files []FileInfo
+
+	root := local.filePath(prefix)
+
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == root {
+				return nil
+			}
+
+			return err
+		}
+
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(local.path, filePath)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, FileInfo{
+			Name: path.Clean(filepath.ToSlash(name)),
+			Size: info.Size(),
+		})
+
+		return nil
+	})
+
+	return files, err
+}
+
+func (local *Local) Delete(name string) error {
+	err := os.Remove(local.filePath(name))
+	if os.IsNotExist(err) {
+		return ErrNotExist
+	}
+
+	return err
+}
+
+func (local *Local) Close() error {
+	return nil
+}
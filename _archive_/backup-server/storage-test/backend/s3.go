@@ -0,0 +1,218 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 connects to s3://endpoint/bucket/prefix. Credentials are taken from
+// the url user info or from AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY.
+func NewS3(uri *url.URL, secure bool) (*S3, error) {
+	parts := strings.SplitN(strings.TrimPrefix(uri.Path, "/"), "/", 2)
+	if len(parts[0]) == 0 {
+		return nil, fmt.Errorf("no bucket in %s", uri.Redacted())
+	}
+
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+
+	if uri.User != nil {
+		accessKey = uri.User.Username()
+		secretKey, _ = uri.User.Password()
+	}
+
+	transport, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := minio.New(uri.Host, &minio.Options{
+		Creds:     credentials.NewStaticV4(accessKey, secretKey, os.Getenv("AWS_SESSION_TOKEN")),
+		Secure:    secure,
+		Region:    os.Getenv("AWS_REGION"),
+		Transport: &s3Transport{RoundTripper: transport},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	store := &S3{
+		client: client,
+		bucket: parts[0],
+	}
+
+	if len(parts) > 1 {
+		store.prefix = strings.Trim(parts[1], "/")
+	}
+
+	return store, nil
+}
+
+type createContextKey struct{}
+
+// s3Transport sends "If-None-Match: *" for the uploads of Create, the
+// client has no option for conditional writes.
+type s3Transport struct {
+	http.RoundTripper
+}
+
+func (transport *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPut && req.Context().Value(createContextKey{}) != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", "*")
+	}
+
+	return transport.RoundTripper.RoundTrip(req)
+}
+
+func (store *S3) objectName(name string) string {
+	return path.Join(store.prefix, name)
+}
+
+func (store *S3) isNotExist(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+func (store *S3) Put(name string, data []byte) error {
+	_, err := store.client.PutObject(
+		context.Background(), store.bucket, store.objectName(name),
+		bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"},
+	)
+
+	return err
+}
+
+func (store *S3) Create(name string, data []byte) error {
+	ctx := context.WithValue(context.Background(), createContextKey{}, true)
+
+	_, err := store.client.PutObject(
+		ctx, store.bucket, store.objectName(name),
+		bytes.NewReader(data), int64(len(data)),
+		// the condition only holds for a single upload
+		minio.PutObjectOptions{ContentType: "application/octet-stream", DisableMultipart: true},
+	)
+
+	if minio.ToErrorResponse(err).StatusCode == http.StatusPreconditionFailed {
+		return ErrExist
+	}
+
+	return err
+}
+
+func (store *S3) get(name string, opts minio.GetObjectOptions) ([]byte, error) {
+	object, err := store.client.GetObject(context.Background(), store.bucket, store.objectName(name), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		if store.isNotExist(err) {
+			return nil, ErrNotExist
+		}
+
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (store *S3) Get(name string) ([]byte, error) {
+	return store.get(name, minio.GetObjectOptions{})
+}
+
+func (store *S3) GetRange(name string, offset, length int64) ([]byte, error) {
+	opts := minio.GetObjectOptions{}
+
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+
+	data, err := store.get(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) != length {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return data, nil
+}
+
+func (store *S3) Stat(name string) (FileInfo, error) {
+	info, err := store.client.StatObject(context.Background(), store.bucket, store.objectName(name), minio.StatObjectOptions{})
+	if err != nil {
+		if store.isNotExist(err) {
+			return FileInfo{}, ErrNotExist
+		}
+
+		return FileInfo{}, err
+	}
+
+	return FileInfo{
+		Name: name,
+		Size: info.Size,
+	}, nil
+}
+
+func (store *S3) List(prefix string) ([]FileInfo, error) {
+	var files []FileInfo
+
+	objectPrefix := store.objectName(prefix)
+	if len(objectPrefix) > 0 && !strings.HasSuffix(objectPrefix, "/") {
+		objectPrefix += "/"
+	}
+
+	objects := store.client.ListObjects(context.Background(), store.bucket, minio.ListObjectsOptions{
+		Prefix:    objectPrefix,
+		Recursive: true,
+	})
+
+	for object := range objects {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		name := strings.TrimPrefix(object.Key, store.prefix)
+
+		files = append(files, FileInfo{
+			Name: strings.TrimPrefix(name, "/"),
+			Size: object.Size,
+		})
+	}
+
+	return files, nil
+}
+
+func (store *S3) Delete(name string) error {
+	if _, err := store.Stat(name); err != nil {
+		return err
+	}
+
+	return store.client.RemoveObject(context.Background(), store.bucket, store.objectName(name), minio.RemoveObjectOptions{})
+}
+
+func (store *S3) Close() error {
+	return nil
+}
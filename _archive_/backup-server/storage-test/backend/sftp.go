@@ -0,0 +1,271 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type SFTP struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	path   string
+}
+
+// NewSFTP connects to sftp://user@host:port/path. Authentication uses the
+// password from the url, the ssh agent and the default private keys; the
+// host key is verified against ~/.ssh/known_hosts.
+func NewSFTP(uri *url.URL) (*SFTP, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	if uri.User != nil {
+		config.User = uri.User.Username()
+
+		if password, ok := uri.User.Password(); ok {
+			config.Auth = append(config.Auth, ssh.Password(password))
+		}
+	}
+
+	if len(config.User) == 0 {
+		config.User = os.Getenv("USER")
+	}
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); len(socket) > 0 {
+		if agentConn, err := net.Dial("unix", socket); err == nil {
+			config.Auth = append(config.Auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		}
+	}
+
+	var signers []ssh.Signer
+
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+
+		if signer, err := ssh.ParsePrivateKey(data); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+
+	if len(signers) > 0 {
+		config.Auth = append(config.Auth, ssh.PublicKeys(signers...))
+	}
+
+	host := uri.Host
+	if len(uri.Port()) == 0 {
+		host = net.JoinHostPort(uri.Hostname(), "22")
+	}
+
+	conn, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &SFTP{
+		conn:   conn,
+		client: client,
+		path:   uri.Path,
+	}, nil
+}
+
+func (store *SFTP) filePath(name string) string {
+	return path.Join(store.path, name)
+}
+
+func (store *SFTP) Put(name string, data []byte) error {
+	filePath := store.filePath(name)
+
+	if err := store.client.MkdirAll(path.Dir(filePath)); err != nil {
+		return err
+	}
+
+	file, err := store.client.Create(filePath + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return store.client.PosixRename(filePath+".tmp", filePath)
+}
+
+func (store *SFTP) Create(name string, data []byte) error {
+	filePath := store.filePath(name)
+
+	if err := store.client.MkdirAll(path.Dir(filePath)); err != nil {
+		return err
+	}
+
+	// a private temporary file, concurrent creates do not share it
+	tmpPath := fmt.Sprintf("%s.%s.tmp", filePath, xid.New())
+
+	file, err := store.client.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+
+	defer store.client.Remove(tmpPath)
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// unlike PosixRename, SSH_FXP_RENAME fails for an existing target, the
+	// status does not tell why
+	if err := store.client.Rename(tmpPath, filePath); err != nil {
+		if _, statErr := store.client.Stat(filePath); statErr == nil {
+			return ErrExist
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (store *SFTP) Get(name string) ([]byte, error) {
+	file, err := store.client.Open(store.filePath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotExist
+		}
+
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+func (store *SFTP) GetRange(name string, offset, length int64) ([]byte, error) {
+	file, err := store.client.Open(store.filePath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotExist
+		}
+
+		return nil, err
+	}
+
+	defer file.Close()
+
+	data := make([]byte, length)
+
+	if _, err := file.ReadAt(data, offset); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (store *SFTP) Stat(name string) (FileInfo, error) {
+	info, err := store.client.Stat(store.filePath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return FileInfo{}, ErrNotExist
+		}
+
+		return FileInfo{}, err
+	}
+
+	return FileInfo{
+		Name: name,
+		Size: info.Size(),
+	}, nil
+}
+
+func (store *SFTP) List(prefix string) ([]FileInfo, error) {
+	var files []FileInfo
+
+	walker := store.client.Walk(store.filePath(prefix))
+
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, os.ErrNotExist) && walker.Path() == store.filePath(prefix) {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		info := walker.Stat()
+		if info.IsDir() || strings.HasSuffix(info.Name(), ".tmp") {
+			continue
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), store.path), "/")
+
+		files = append(files, FileInfo{
+			Name: name,
+			Size: info.Size(),
+		})
+	}
+
+	return files, nil
+}
+
+func (store *SFTP) Delete(name string) error {
+	err := store.client.Remove(store.filePath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotExist
+	}
+
+	return err
+}
+
+func (store *SFTP) Close() error {
+	if err := store.client.Close(); err != nil {
+		return fmt.Errorf("sftp: %w", err)
+	}
+
+	return store.conn.Close()
+}
@@ -3,10 +3,9 @@ package cmd
 import (
 	"errors"
 	"io"
-	"log"
 	"os"
+	"time"
 
-	"github.com/cheggaaa/pb/v3"
 	"github.com/urfave/cli/v2"
 	"github.com/vitalvas/backup-server/storage-test/storage"
 )
@@ -14,64 +13,191 @@ import (
 func newBackupCommand() *cli.Command {
 	return &cli.Command{
 		Name: "backup",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:  "file",
 				Usage: "path to file",
 			},
+			&cli.StringFlag{
+				Name:  "path",
+				Usage: "path to directory",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "snapshot tag, can be repeated",
+			},
+			&cli.StringFlag{
+				Name:  "hostname",
+				Usage: "hostname recorded in snapshot (default: current hostname)",
+			},
 			&cli.BoolFlag{
 				Name:  "stdin",
 				Value: false,
 				Usage: "read file from stdin",
 			},
+			&cli.IntFlag{
+				Name:  "workers",
+				Usage: "number of hash/compress workers (default: number of CPUs, 1 disables the pipeline)",
+			},
+			&cli.IntFlag{
+				Name:  "upload-workers",
+				Usage: "number of concurrent pack uploads",
+				Value: 2,
+			},
+			&cli.StringFlag{
+				Name:  "max-memory",
+				Usage: "limit for chunk data held in the pipeline",
+				Value: "256MB",
+			},
+			&cli.DurationFlag{
+				Name:  "checkpoint-interval",
+				Value: 5 * time.Minute,
+				Usage: "write a partial manifest this often, 0 disables checkpoints",
+			},
+			&cli.StringFlag{
+				Name:  "resume",
+				Usage: "continue the interrupted backup with this block id, requires --file",
+			},
+			&cli.StringFlag{
+				Name:  "archive",
+				Usage: "parse the input as tar or cpio archive and record its members",
+			},
 			&cli.BoolFlag{
 				Name:  "discard",
 				Value: false,
-				Usage: "dont write blob to filesystem",
+				Usage: "dont write blob to storage",
 			},
-		},
+			&cli.BoolFlag{
+				Name:  "json",
+				Value: false,
+				Usage: "print progress events and the summary as json lines",
+			},
+			&cli.DurationFlag{
+				Name:  "progress-interval",
+				Value: time.Second,
+				Usage: "time between json progress events",
+			},
+		}, append(throttleFlags(), metricsFlags()...)...),
 		Action: func(c *cli.Context) error {
-			var reader io.Reader
-			var size int64
+			report := newBackupReport(c)
 
-			if c.Bool("stdin") {
-				reader = os.Stdin
+			var stats *storage.WriteStats
+			var err error
 
-			} else if len(c.String("file")) > 0 {
-				file, err := os.Open(c.String("file"))
-				if err != nil {
-					return err
-				}
+			if len(c.String("path")) > 0 {
+				stats, err = backupPath(c, report)
+			} else {
+				stats, err = backupStream(c, report)
+			}
 
-				defer file.Close()
+			return report.finish(stats, err)
+		},
+	}
+}
 
-				info, err := file.Stat()
-				if err != nil {
-					return err
-				}
+func backupStream(c *cli.Context, report *backupReport) (stats *storage.WriteStats, err error) {
+	var reader io.Reader
+	var size int64
 
-				size = info.Size()
-				reader = file
+	opts := storage.WriteOptions{
+		CheckpointInterval: c.Duration("checkpoint-interval"),
+		Resume:             c.String("resume"),
+		Archive:            c.String("archive"),
+		Progress:           report.progress,
+	}
 
-			} else {
-				return errors.New("no incoming data")
+	if c.Bool("stdin") {
+		if len(opts.Resume) > 0 {
+			return nil, errors.New("stdin can not be resumed")
+		}
+
+		reader = os.Stdin
+		opts.Source = "stdin"
+
+	} else if len(c.String("file")) > 0 {
+		file, err := os.Open(c.String("file"))
+		if err != nil {
+			return nil, err
+		}
+
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+
+		size = info.Size()
+		reader = file
+		opts.Source = c.String("file")
+
+	} else {
+		return nil, errors.New("no incoming data")
+	}
+
+	store, err := openStorage(c, c.Bool("discard"), storage.LockShared)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeStorage(store, &err)
+
+	report.start(size)
+
+	stop, err := startThrottles(c, report.bar, store)
+	if err != nil {
+		return nil, err
+	}
+
+	defer stop()
+
+	opts.Reader = func(reader io.Reader) io.Reader {
+		// a resumed backup starts behind the checkpoint
+		if seeker, ok := reader.(io.Seeker); ok {
+			if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+				report.setCurrent(offset)
 			}
+		}
+
+		return report.reader(reader)
+	}
+
+	return store.Writer(reader, opts)
+}
 
-			store := storage.New(storage.StorageConfig{
-				Discard: c.Bool("discard"),
-				Path:    c.String("storage-path"),
-			})
+func backupPath(c *cli.Context, report *backupReport) (stats *storage.WriteStats, err error) {
+	hostname := c.String("hostname")
+	if len(hostname) == 0 {
+		var err error
 
-			bar := pb.Full.Start64(size)
-			barReader := bar.NewProxyReader(reader)
+		hostname, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			stats := store.Writer(barReader)
+	store, err := openStorage(c, c.Bool("discard"), storage.LockShared)
+	if err != nil {
+		return nil, err
+	}
 
-			bar.Finish()
+	defer closeStorage(store, &err)
 
-			log.Print(stats)
+	report.start(0)
 
-			return nil
-		},
+	stop, err := startThrottles(c, report.bar, store)
+	if err != nil {
+		return nil, err
 	}
+
+	defer stop()
+
+	_, stats, err = store.BackupPath(c.String("path"), storage.SnapshotOptions{
+		Hostname: hostname,
+		Tags:     c.StringSlice("tag"),
+		Reader:   report.reader,
+		Progress: report.progress,
+	})
+
+	return stats, err
 }
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func cacheFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "cache-size",
+			Value: "256MB",
+			Usage: "memory used to cache chunks",
+		},
+		&cli.StringFlag{
+			Name:  "cache-dir",
+			Usage: "directory caching the chunks read from the repository, they stay encrypted",
+		},
+		&cli.StringFlag{
+			Name:  "cache-dir-size",
+			Value: "4GB",
+			Usage: "disk space used by --cache-dir",
+		},
+	}
+}
+
+func newChunkCache(c *cli.Context, store *storage.Storage) (*storage.ChunkCache, error) {
+	cacheSize, err := humanize.ParseBytes(c.String("cache-size"))
+	if err != nil {
+		return nil, err
+	}
+
+	cache := storage.NewChunkCache(store, cacheSize)
+
+	if dir := c.String("cache-dir"); len(dir) > 0 {
+		dirSize, err := humanize.ParseBytes(c.String("cache-dir-size"))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := cache.SetDisk(dir, dirSize); err != nil {
+			return nil, err
+		}
+	}
+
+	return cache, nil
+}
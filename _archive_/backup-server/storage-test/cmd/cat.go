@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newCatCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "cat",
+		Usage:     "print a block, or a file from a snapshot, to stdout",
+		ArgsUsage: "BLOCK-ID | SNAPSHOT-ID PATH",
+		Action: func(c *cli.Context) (err error) {
+			if c.NArg() < 1 {
+				return errors.New("block or snapshot id required")
+			}
+
+			store, err := openStorage(c, false, storage.LockShared)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			var blobs []storage.Blob
+			var checksum string
+
+			if c.NArg() == 1 {
+				block, err := store.GetBlock(c.Args().Get(0))
+				if err != nil {
+					return err
+				}
+
+				blobs = block.Blobs
+				checksum = block.CheckSum
+
+			} else {
+				snapshot, err := store.GetSnapshot(c.Args().Get(0))
+				if err != nil {
+					return err
+				}
+
+				node, err := store.FindNode(snapshot, c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+
+				if node.Type != storage.NodeTypeFile {
+					return fmt.Errorf("%s: not a regular file", c.Args().Get(1))
+				}
+
+				blobs = node.Content
+				checksum = node.CheckSum
+			}
+
+			return store.RestoreStream(os.Stdout, blobs, checksum, storage.RestoreOptions{})
+		},
+	}
+}
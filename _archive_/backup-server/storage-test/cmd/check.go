@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newCheckCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "check",
+		Usage: "verify repository integrity",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "read-data",
+				Value: false,
+				Usage: "read all chunks and verify content and stream checksums",
+			},
+			&cli.StringFlag{
+				Name:  "read-data-subset",
+				Usage: "read and verify a random percentage of chunks, e.g. 10%",
+			},
+			jsonFlag,
+		},
+		Action: func(c *cli.Context) (err error) {
+			opts := storage.CheckOptions{
+				ReadData: c.Bool("read-data"),
+			}
+
+			if subset := c.String("read-data-subset"); len(subset) > 0 {
+				percent, err := strconv.ParseFloat(strings.TrimSuffix(subset, "%"), 64)
+				if err != nil || percent <= 0 || percent > 100 {
+					return fmt.Errorf("invalid subset: %s", subset)
+				}
+
+				opts.ReadDataSubset = percent
+			}
+
+			store, err := openStorage(c, false, storage.LockShared)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			report, err := store.Check(opts)
+			if err != nil {
+				return err
+			}
+
+			if c.Bool("json") {
+				if err := printJSON(report); err != nil {
+					return err
+				}
+
+			} else {
+				for _, checkErr := range report.Errors {
+					log.Printf("%s %s: %s", checkErr.Type, checkErr.ID, checkErr.Message)
+				}
+
+				log.Printf(
+					"blocks: %d, snapshots: %d, trees: %d, chunks: %d, chunks read: %d, streams hashed: %d, errors: %d",
+					report.Blocks, report.Snapshots, report.Trees, report.Chunks,
+					report.ChunksRead, report.StreamsHashed, len(report.Errors),
+				)
+			}
+
+			if len(report.Errors) > 0 {
+				return fmt.Errorf("repository check found %d errors: %w", len(report.Errors), storage.ErrCorrupted)
+			}
+
+			return nil
+		},
+	}
+}
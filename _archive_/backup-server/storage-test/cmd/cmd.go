@@ -15,14 +15,53 @@ func Execute() {
 				Name:  "storage-path",
 				Value: "./test",
 			},
+			&cli.StringFlag{
+				Name:  "server",
+				Usage: "backup server url, used instead of --storage-path",
+			},
+			&cli.StringFlag{
+				Name:    "server-token",
+				EnvVars: []string{"BACKUP_SERVER_TOKEN"},
+				Usage:   "backup server access token",
+			},
+			&cli.StringFlag{
+				Name:    "password",
+				EnvVars: []string{"STORAGE_PASSWORD"},
+				Usage:   "repository password",
+			},
+			&cli.StringFlag{
+				Name:  "password-file",
+				Usage: "read repository password from file",
+			},
+			&cli.BoolFlag{
+				Name:  "no-lock",
+				Value: false,
+				Usage: "do not take a shared lock, e.g. for read-only repositories",
+			},
 		},
 		Commands: []*cli.Command{
+			newInitCommand(),
 			newBackupCommand(),
 			newRestoreCommand(),
+			newSnapshotsCommand(),
+			newPartialCommand(),
+			newLsCommand(),
+			newCatCommand(),
+			newDiffCommand(),
+			newMountCommand(),
+			newCopyCommand(),
+			newMigrateCommand(),
+			newCheckCommand(),
+			newForgetCommand(),
+			newPruneCommand(),
+			newRebuildIndexCommand(),
+			newUnlockCommand(),
+			newStatsCommand(),
 		},
 	}
 
 	if err := cliApp.Run(os.Args); err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newCopyCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "copy",
+		Usage:     "copy blocks into another repository, transferring only missing chunks",
+		ArgsUsage: "[BLOCK-ID...]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "to",
+				Usage:    "destination repository, created if it does not exist",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:    "to-password",
+				EnvVars: []string{"STORAGE_TO_PASSWORD"},
+				Usage:   "destination repository password",
+			},
+			&cli.StringFlag{
+				Name:  "to-password-file",
+				Usage: "read destination repository password from file",
+			},
+			&cli.StringFlag{
+				Name:  "since",
+				Usage: "only copy blocks created at or after this time, e.g. 2022-07-01",
+			},
+			&cli.StringFlag{
+				Name:  "until",
+				Usage: "only copy blocks created at or before this time",
+			},
+			&cli.BoolFlag{
+				Name:  "snapshots",
+				Value: false,
+				Usage: "copy the snapshots within --since and --until as well",
+			},
+		},
+		Action: func(c *cli.Context) (err error) {
+			opts := storage.CopyOptions{
+				BlockIDs:  c.Args().Slice(),
+				Snapshots: c.Bool("snapshots"),
+			}
+
+			if opts.Since, err = parseTimeFlag(c, "since"); err != nil {
+				return err
+			}
+
+			if opts.Until, err = parseTimeFlag(c, "until"); err != nil {
+				return err
+			}
+
+			store, err := openStorage(c, false, storage.LockShared)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			dst, err := openDestination(c, store)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(dst, &err)
+
+			report, err := store.Copy(dst, opts)
+			if err != nil {
+				return err
+			}
+
+			log.Printf(
+				"blocks copied: %d, skipped: %d, snapshots copied: %d, skipped: %d, chunks: %d, chunks copied: %d (%s)",
+				report.Blocks, report.SkippedBlocks, report.Snapshots, report.SkippedSnapshots, report.Chunks,
+				report.CopiedChunks, humanize.Bytes(report.CopiedBytes),
+			)
+
+			return nil
+		},
+	}
+}
+
+// parseTimeFlag accepts a date or an RFC 3339 timestamp and returns it as
+// unix time, zero when the flag is unset.
+func parseTimeFlag(c *cli.Context, name string) (int64, error) {
+	value := c.String(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed.Unix(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid time for --%s: %s", name, value)
+}
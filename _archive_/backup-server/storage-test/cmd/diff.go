@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newDiffCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "show changes between two blocks or two snapshots",
+		ArgsUsage: "OLD-ID NEW-ID",
+		Flags: []cli.Flag{
+			jsonFlag,
+		},
+		Action: func(c *cli.Context) (err error) {
+			if c.NArg() != 2 {
+				return errors.New("two block or snapshot ids required")
+			}
+
+			store, err := openStorage(c, false, storage.LockShared)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			oldID, newID := c.Args().Get(0), c.Args().Get(1)
+
+			_, err = store.GetBlock(oldID)
+
+			switch {
+			case err == nil:
+				return diffBlocks(c, store, oldID, newID)
+
+			case errors.Is(err, storage.ErrNotFound):
+				return diffSnapshots(c, store, oldID, newID)
+
+			default:
+				return err
+			}
+		},
+	}
+}
+
+func diffBlocks(c *cli.Context, store *storage.Storage, oldID, newID string) error {
+	diff, err := store.DiffBlocks(oldID, newID)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		return printJSON(diff)
+	}
+
+	table := newTable(os.Stdout)
+
+	fmt.Fprintln(table, "OFFSET\tEND\tLENGTH")
+
+	for _, changed := range diff.Changed {
+		fmt.Fprintf(table, "%d\t%d\t%s\n", changed.Offset, changed.Offset+changed.Length, humanize.Bytes(changed.Length))
+	}
+
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	log.Printf(
+		"size: %s -> %s, shared: %d chunks (%s), new: %d chunks (%s), removed: %d chunks (%s)",
+		humanize.Bytes(diff.OldSize), humanize.Bytes(diff.NewSize),
+		diff.SharedChunks, humanize.Bytes(diff.SharedBytes),
+		diff.NewChunks, humanize.Bytes(diff.NewBytes),
+		diff.RemovedChunks, humanize.Bytes(diff.RemovedBytes),
+	)
+
+	return nil
+}
+
+func diffSnapshots(c *cli.Context, store *storage.Storage, oldID, newID string) error {
+	changes, err := store.DiffSnapshots(oldID, newID)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		return printJSON(changes)
+	}
+
+	counts := make(map[string]int)
+
+	for _, change := range changes {
+		counts[change.Change]++
+
+		switch change.Change {
+		case storage.ChangeAdded:
+			fmt.Printf("+ %s\n", change.Path)
+		case storage.ChangeRemoved:
+			fmt.Printf("- %s\n", change.Path)
+		default:
+			fmt.Printf("M %s\n", change.Path)
+		}
+	}
+
+	log.Printf(
+		"added: %d, removed: %d, modified: %d",
+		counts[storage.ChangeAdded], counts[storage.ChangeRemoved], counts[storage.ChangeModified],
+	)
+
+	return nil
+}
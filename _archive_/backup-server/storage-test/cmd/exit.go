@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+const (
+	exitError     = 1
+	exitNotFound  = 2
+	exitCorrupted = 3
+	exitIO        = 4
+	exitLocked    = 5
+)
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return exitNotFound
+
+	case errors.Is(err, storage.ErrCorrupted):
+		return exitCorrupted
+
+	case errors.Is(err, storage.ErrIO):
+		return exitIO
+
+	case errors.Is(err, storage.ErrLocked):
+		return exitLocked
+
+	default:
+		return exitError
+	}
+}
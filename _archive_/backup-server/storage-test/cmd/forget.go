@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newForgetCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "forget",
+		Usage: "remove blocks and snapshots according to a retention policy",
+		Flags: []cli.Flag{
+			&cli.IntFlag{Name: "keep-last", Usage: "keep the last n blocks/snapshots"},
+			&cli.IntFlag{Name: "keep-hourly", Usage: "keep the last n hourly blocks/snapshots"},
+			&cli.IntFlag{Name: "keep-daily", Usage: "keep the last n daily blocks/snapshots"},
+			&cli.IntFlag{Name: "keep-weekly", Usage: "keep the last n weekly blocks/snapshots"},
+			&cli.IntFlag{Name: "keep-monthly", Usage: "keep the last n monthly blocks/snapshots"},
+			&cli.IntFlag{Name: "keep-yearly", Usage: "keep the last n yearly blocks/snapshots"},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Value: false,
+				Usage: "only show what would be removed",
+			},
+			&cli.BoolFlag{
+				Name:  "prune",
+				Value: false,
+				Usage: "run prune after forget",
+			},
+		},
+		Action: func(c *cli.Context) (err error) {
+			policy := storage.RetentionPolicy{
+				Last:    c.Int("keep-last"),
+				Hourly:  c.Int("keep-hourly"),
+				Daily:   c.Int("keep-daily"),
+				Weekly:  c.Int("keep-weekly"),
+				Monthly: c.Int("keep-monthly"),
+				Yearly:  c.Int("keep-yearly"),
+			}
+
+			if policy.Empty() {
+				return errors.New("no retention policy given")
+			}
+
+			store, err := openStorage(c, false, storage.LockExclusive)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			var items []storage.RetentionItem
+
+			blocks, err := store.ListBlocks()
+			if err != nil {
+				return err
+			}
+
+			for _, id := range blocks {
+				block, err := store.GetBlock(id)
+				if err != nil {
+					return err
+				}
+
+				items = append(items, storage.RetentionItem{
+					ID:        id,
+					Type:      "block",
+					Group:     fmt.Sprintf("block %s", block.Source),
+					Timestamp: block.Timestamp,
+				})
+			}
+
+			snapshots, err := store.ListSnapshots()
+			if err != nil {
+				return err
+			}
+
+			for _, id := range snapshots {
+				snapshot, err := store.GetSnapshot(id)
+				if err != nil {
+					return err
+				}
+
+				items = append(items, storage.RetentionItem{
+					ID:        id,
+					Type:      "snapshot",
+					Group:     fmt.Sprintf("snapshot %s %s", snapshot.Hostname, strings.Join(snapshot.Paths, ",")),
+					Timestamp: snapshot.Timestamp,
+				})
+			}
+
+			result := storage.ApplyRetention(items, policy)
+
+			table := newTable(os.Stdout)
+
+			fmt.Fprintln(table, "ACTION\tTYPE\tID\tTIME")
+
+			for _, item := range result.Keep {
+				fmt.Fprintf(table, "keep\t%s\t%s\t%s\n", item.Type, item.ID, formatTime(item.Timestamp))
+			}
+
+			for _, item := range result.Remove {
+				fmt.Fprintf(table, "remove\t%s\t%s\t%s\n", item.Type, item.ID, formatTime(item.Timestamp))
+			}
+
+			if err := table.Flush(); err != nil {
+				return err
+			}
+
+			if c.Bool("dry-run") {
+				return nil
+			}
+
+			for _, item := range result.Remove {
+				if item.Type == "snapshot" {
+					err = store.DeleteSnapshot(item.ID)
+				} else {
+					err = store.DeleteBlock(item.ID)
+				}
+
+				if err != nil {
+					return err
+				}
+			}
+
+			if c.Bool("prune") {
+				return runPrune(store, false)
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+	"github.com/restic/chunker"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newInitCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "init",
+		Usage: "create a repository with the given chunker and compression parameters",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "chunker-min-size",
+				Value: "256KiB",
+				Usage: "minimal chunk size",
+			},
+			&cli.StringFlag{
+				Name:  "chunker-avg-size",
+				Value: "1MiB",
+				Usage: "average chunk size, a power of two",
+			},
+			&cli.StringFlag{
+				Name:  "chunker-max-size",
+				Value: "8MiB",
+				Usage: "maximal chunk size",
+			},
+			&cli.StringFlag{
+				Name:  "chunker-polynomial",
+				Usage: "chunker polynomial in hex, random by default",
+			},
+			&cli.StringFlag{
+				Name:  "chunk-hash",
+				Value: storage.ChunkHashHighwayHash,
+				Usage: "chunk ID hash: highwayhash-256, blake3-256 or hmac-sha256",
+			},
+			&cli.StringFlag{
+				Name:  "compression",
+				Value: storage.CompressionS2,
+				Usage: "chunk compression: s2, zstd or none",
+			},
+			&cli.IntFlag{
+				Name:  "compression-level",
+				Usage: "zstd compression level, 1-22",
+			},
+			&cli.BoolFlag{
+				Name:  "compression-adaptive",
+				Value: false,
+				Usage: "store chunks uncompressed when a trial compression gains little",
+			},
+		},
+		Action: func(c *cli.Context) (err error) {
+			conf, err := storageConfig(c, false)
+			if err != nil {
+				return err
+			}
+
+			if err := confirmPassword(c, "password", "password-file", conf.Password); err != nil {
+				return err
+			}
+
+			conf.Create = true
+			conf.ChunkHash = c.String("chunk-hash")
+			conf.Compression = storage.CompressionParams{
+				Codec:    c.String("compression"),
+				Level:    c.Int("compression-level"),
+				Adaptive: c.Bool("compression-adaptive"),
+			}
+
+			sizes := []struct {
+				flag  string
+				value *uint
+			}{
+				{"chunker-min-size", &conf.Chunker.MinSize},
+				{"chunker-avg-size", &conf.Chunker.AvgSize},
+				{"chunker-max-size", &conf.Chunker.MaxSize},
+			}
+
+			for _, size := range sizes {
+				bytes, err := humanize.ParseBytes(c.String(size.flag))
+				if err != nil {
+					return err
+				}
+
+				*size.value = uint(bytes)
+			}
+
+			if pol := c.String("chunker-polynomial"); len(pol) > 0 {
+				value, err := strconv.ParseUint(pol, 16, 64)
+				if err != nil {
+					return err
+				}
+
+				conf.Chunker.Polynomial = chunker.Pol(value)
+			}
+
+			store, err := storage.New(conf)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			repoConfig := store.Config()
+			params := repoConfig.Chunker
+
+			log.Printf(
+				"repository created, chunker polynomial: %s, min: %s, avg: %s, max: %s, compression: %s, chunk hash: %s",
+				params.Polynomial, humanize.IBytes(uint64(params.MinSize)),
+				humanize.IBytes(uint64(params.AvgSize)), humanize.IBytes(uint64(params.MaxSize)),
+				repoConfig.Compression.Codec, repoConfig.ChunkHash,
+			)
+
+			return nil
+		},
+	}
+}
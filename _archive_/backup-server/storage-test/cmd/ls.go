@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+type lsEntry struct {
+	Path       string `json:"path"`
+	Type       string `json:"type"`
+	Mode       string `json:"mode"`
+	UID        uint32 `json:"uid"`
+	GID        uint32 `json:"gid"`
+	Size       uint64 `json:"size"`
+	ModTime    string `json:"mtime"`
+	LinkTarget string `json:"link_target,omitempty"`
+}
+
+func newLsCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "ls",
+		Usage:     "list files in a snapshot or members of an archive block",
+		ArgsUsage: "SNAPSHOT-ID|BLOCK-ID [PATH]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "recursive",
+				Value: false,
+				Usage: "list subdirectories recursively",
+			},
+			jsonFlag,
+		},
+		Action: func(c *cli.Context) (err error) {
+			if c.NArg() < 1 {
+				return errors.New("snapshot id required")
+			}
+
+			store, err := openStorage(c, false, storage.LockShared)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			dir := path.Join("/", c.Args().Get(1))
+
+			var entries []lsEntry
+
+			snapshot, err := store.GetSnapshot(c.Args().Get(0))
+
+			switch {
+			case err == nil:
+				if entries, err = listSnapshot(c, store, snapshot, dir); err != nil {
+					return err
+				}
+
+			case errors.Is(err, storage.ErrNotFound):
+				// archive blocks list their members
+				block, blockErr := store.GetBlock(c.Args().Get(0))
+				if blockErr != nil {
+					return err
+				}
+
+				entries = listMembers(block, dir)
+
+			default:
+				return err
+			}
+
+			if c.Bool("json") {
+				return printJSON(entries)
+			}
+
+			table := newTable(os.Stdout)
+
+			for _, entry := range entries {
+				name := entry.Path
+				if len(entry.LinkTarget) > 0 {
+					name = fmt.Sprintf("%s -> %s", name, entry.LinkTarget)
+				}
+
+				fmt.Fprintf(
+					table, "%s\t%d\t%d\t%s\t%s\t%s\n",
+					entry.Mode, entry.UID, entry.GID, humanize.Bytes(entry.Size), entry.ModTime, name,
+				)
+			}
+
+			return table.Flush()
+		},
+	}
+}
+
+func newLsEntry(name string, node *storage.Node) lsEntry {
+	return lsEntry{
+		Path:       name,
+		Type:       node.Type,
+		Mode:       os.FileMode(node.Mode).String(),
+		UID:        node.UID,
+		GID:        node.GID,
+		Size:       node.Size,
+		ModTime:    time.Unix(0, node.ModTime).Local().Format(time.RFC3339),
+		LinkTarget: node.LinkTarget,
+	}
+}
+
+func listSnapshot(c *cli.Context, store *storage.Storage, snapshot *storage.Snapshot, dir string) ([]lsEntry, error) {
+	node, err := store.FindNode(snapshot, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []lsEntry
+
+	add := func(name string, node *storage.Node) error {
+		entries = append(entries, newLsEntry(name, node))
+		return nil
+	}
+
+	switch {
+	case node.Type != storage.NodeTypeDir:
+		add(dir, node)
+
+	case c.Bool("recursive"):
+		if err := store.WalkTree(node.Subtree, dir, add); err != nil {
+			return nil, err
+		}
+
+	default:
+		tree, err := store.GetTree(node.Subtree)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, child := range tree.Nodes {
+			child := child
+			add(path.Join(dir, child.Name), &child)
+		}
+	}
+
+	return entries, nil
+}
+
+// listMembers returns the archive members below dir, members are listed
+// as stored, so the listing is always recursive.
+func listMembers(block *storage.Block, dir string) []lsEntry {
+	var entries []lsEntry
+
+	for _, member := range block.Members {
+		name := path.Join("/", member.Name)
+
+		if dir != "/" && name != dir && !strings.HasPrefix(name, dir+"/") {
+			continue
+		}
+
+		entries = append(entries, newLsEntry(name, &storage.Node{
+			Type:       member.Type,
+			Mode:       member.Mode,
+			UID:        member.UID,
+			GID:        member.GID,
+			ModTime:    member.ModTime,
+			Size:       member.Size,
+			LinkTarget: member.LinkTarget,
+		}))
+	}
+
+	return entries
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+const metricsPushTimeout = 30 * time.Second
+
+func metricsFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "metrics-file",
+			Usage: "write prometheus metrics of the run to this file, e.g. for the node_exporter textfile collector",
+		},
+		&cli.StringFlag{
+			Name:  "metrics-push",
+			Usage: "push prometheus metrics of the run to this pushgateway url",
+		},
+		&cli.StringFlag{
+			Name:  "metrics-job",
+			Value: "backup",
+			Usage: "job name of the exported metrics",
+		},
+	}
+}
+
+type metric struct {
+	name  string
+	help  string
+	value float64
+}
+
+// backupMetrics returns the metrics of a run, a failed run only reports
+// its status.
+func backupMetrics(stats *storage.WriteStats, runErr error) []metric {
+	success := 1.0
+	if runErr != nil {
+		success = 0
+	}
+
+	metrics := []metric{
+		{"backup_success", "Whether the last backup succeeded.", success},
+		{"backup_last_run_timestamp_seconds", "Time the last backup finished.", float64(time.Now().Unix())},
+	}
+
+	if runErr != nil || stats == nil {
+		return metrics
+	}
+
+	return append(metrics,
+		metric{"backup_duration_seconds", "Duration of the last backup.", stats.Duration.Seconds()},
+		metric{"backup_read_bytes", "Bytes read by the last backup.", float64(stats.BytesRead)},
+		metric{"backup_new_bytes", "Bytes of new chunks in the last backup.", float64(stats.BytesNew)},
+		metric{"backup_stored_bytes", "Bytes stored in the repository by the last backup.", float64(stats.BytesStored)},
+		metric{"backup_chunks", "Chunks read by the last backup.", float64(stats.Chunks)},
+		metric{"backup_new_chunks", "New chunks of the last backup.", float64(stats.ChunksNew)},
+		metric{"backup_throughput_bytes_per_second", "Read throughput of the last backup.", stats.Throughput()},
+		metric{"backup_compression_ratio", "New bytes per stored byte of the last backup.", stats.CompressionRatio()},
+	)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics writes the metrics in the prometheus text format.
+func writeMetrics(writer io.Writer, metrics []metric, labels map[string]string) error {
+	var labelText string
+
+	if len(labels) > 0 {
+		var pairs []string
+
+		for name, value := range labels {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(value)))
+		}
+
+		labelText = "{" + strings.Join(pairs, ",") + "}"
+	}
+
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s gauge\n%s%s %g\n", m.name, m.help, m.name, m.name, labelText, m.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportMetrics writes the --metrics-file and pushes to --metrics-push.
+func exportMetrics(c *cli.Context, stats *storage.WriteStats, runErr error) error {
+	metrics := backupMetrics(stats, runErr)
+	job := c.String("metrics-job")
+
+	if path := c.String("metrics-file"); len(path) > 0 {
+		if err := writeMetricsFile(path, metrics, map[string]string{"job": job}); err != nil {
+			return err
+		}
+	}
+
+	if target := c.String("metrics-push"); len(target) > 0 {
+		if err := pushMetrics(target, job, metrics); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeMetricsFile replaces the file atomically, so collectors never read
+// a partial file.
+func writeMetricsFile(path string, metrics []metric, labels map[string]string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".metrics-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	if err := writeMetrics(file, metrics, labels); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// pushMetrics replaces the metrics of the job group on a pushgateway.
+func pushMetrics(target, job string, metrics []metric) error {
+	var body bytes.Buffer
+
+	// the job label is set by the grouping key
+	if err := writeMetrics(&body, metrics, nil); err != nil {
+		return err
+	}
+
+	uri := strings.TrimRight(target, "/") + "/metrics/job/" + url.PathEscape(job)
+
+	req, err := http.NewRequest(http.MethodPut, uri, &body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: metricsPushTimeout}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pushgateway: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newMigrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "upgrade the repository to the current format version, in place or into a new repository",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "copy blocks and snapshots into this repository instead of upgrading in place, required for the unencrypted layout",
+			},
+			&cli.StringFlag{
+				Name:    "to-password",
+				EnvVars: []string{"STORAGE_TO_PASSWORD"},
+				Usage:   "destination repository password",
+			},
+			&cli.StringFlag{
+				Name:  "to-password-file",
+				Usage: "read destination repository password from file",
+			},
+		},
+		Action: func(c *cli.Context) (err error) {
+			if len(c.String("to")) > 0 {
+				return migrateInto(c)
+			}
+
+			store, err := openStorage(c, false, storage.LockExclusive)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			report, err := store.Migrate()
+			if err != nil {
+				return err
+			}
+
+			if report.FromVersion == report.ToVersion {
+				log.Printf("repository is at format version %d", report.ToVersion)
+				return nil
+			}
+
+			log.Printf("format version %d -> %d", report.FromVersion, report.ToVersion)
+
+			return nil
+		},
+	}
+}
+
+// migrateInto copies the repository into a repository of the current
+// format version, the source is left unchanged.
+func migrateInto(c *cli.Context) (err error) {
+	store, err := openStorage(c, false, storage.LockShared)
+	if errors.Is(err, storage.ErrPlainRepository) {
+		return importPlain(c)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer closeStorage(store, &err)
+
+	dst, err := openDestination(c, store)
+	if err != nil {
+		return err
+	}
+
+	defer closeStorage(dst, &err)
+
+	report, err := store.Copy(dst, storage.CopyOptions{Snapshots: true})
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"format version %d -> %d, blocks copied: %d, snapshots copied: %d, chunks copied: %d (%s)",
+		store.FormatVersion(), dst.FormatVersion(), report.Blocks, report.Snapshots,
+		report.CopiedChunks, humanize.Bytes(report.CopiedBytes),
+	)
+
+	return nil
+}
+
+// importPlain imports a repository of the unencrypted layout of the first
+// versions, blocks are chunked again with the destination parameters.
+func importPlain(c *cli.Context) (err error) {
+	location, err := storageLocation(c)
+	if err != nil {
+		return err
+	}
+
+	dst, err := openDestination(c, nil)
+	if err != nil {
+		return err
+	}
+
+	defer closeStorage(dst, &err)
+
+	report, err := dst.ImportPlain(location)
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"unencrypted layout -> format version %d, blocks imported: %d, skipped: %d, chunks: %d, chunks written: %d (%s stored)",
+		dst.FormatVersion(), report.Blocks, report.SkippedBlocks, report.Chunks, report.ChunksNew, humanize.Bytes(report.StoredBytes),
+	)
+
+	return nil
+}
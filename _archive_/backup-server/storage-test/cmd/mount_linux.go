@@ -0,0 +1,351 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newMountCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "mount",
+		Usage:     "mount blocks and snapshots as a read-only filesystem",
+		ArgsUsage: "MOUNTPOINT",
+		Flags:     cacheFlags(),
+		Action: func(c *cli.Context) (err error) {
+			if c.NArg() < 1 {
+				return errors.New("mountpoint required")
+			}
+
+			store, err := openStorage(c, false, storage.LockShared)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			cache, err := newChunkCache(c, store)
+			if err != nil {
+				return err
+			}
+
+			mountpoint := c.Args().Get(0)
+
+			conn, err := fuse.Mount(
+				mountpoint,
+				fuse.ReadOnly(),
+				fuse.FSName("storage-test"),
+				fuse.Subtype("storage-test"),
+			)
+			if err != nil {
+				return err
+			}
+
+			defer conn.Close()
+
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+			go func() {
+				<-signals
+
+				if err := fuse.Unmount(mountpoint); err != nil {
+					log.Printf("unmount: %s", err)
+				}
+			}()
+
+			log.Printf("mounted at %s, press Ctrl-C to unmount", mountpoint)
+
+			filesys := &mountFS{
+				store: store,
+				cache: cache,
+			}
+
+			if err := fs.Serve(conn, filesys); err != nil {
+				return err
+			}
+
+			<-conn.Ready
+
+			return conn.MountError
+		},
+	}
+}
+
+type mountFS struct {
+	store *storage.Storage
+	cache *storage.ChunkCache
+}
+
+func (filesys *mountFS) Root() (fs.Node, error) {
+	return &mountRoot{filesys: filesys}, nil
+}
+
+// mountError hides the storage error kinds behind the errno the kernel expects.
+func mountError(err error) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return fuse.ENOENT
+	}
+
+	log.Print(err)
+
+	return fuse.Errno(syscall.EIO)
+}
+
+type mountRoot struct {
+	filesys *mountFS
+}
+
+func (root *mountRoot) Attr(_ context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeDir | 0555
+	return nil
+}
+
+func (root *mountRoot) ReadDirAll(_ context.Context) ([]fuse.Dirent, error) {
+	return []fuse.Dirent{
+		{Name: "blocks", Type: fuse.DT_Dir},
+		{Name: "snapshots", Type: fuse.DT_Dir},
+	}, nil
+}
+
+func (root *mountRoot) Lookup(_ context.Context, name string) (fs.Node, error) {
+	switch name {
+	case "blocks":
+		return &mountBlocks{filesys: root.filesys}, nil
+	case "snapshots":
+		return &mountSnapshots{filesys: root.filesys}, nil
+	}
+
+	return nil, fuse.ENOENT
+}
+
+type mountBlocks struct {
+	filesys *mountFS
+}
+
+func (dir *mountBlocks) Attr(_ context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeDir | 0555
+	return nil
+}
+
+func (dir *mountBlocks) ReadDirAll(_ context.Context) ([]fuse.Dirent, error) {
+	ids, err := dir.filesys.store.ListBlocks()
+	if err != nil {
+		return nil, mountError(err)
+	}
+
+	entries := make([]fuse.Dirent, 0, len(ids))
+	for _, id := range ids {
+		entries = append(entries, fuse.Dirent{Name: id, Type: fuse.DT_File})
+	}
+
+	return entries, nil
+}
+
+func (dir *mountBlocks) Lookup(_ context.Context, name string) (fs.Node, error) {
+	block, err := dir.filesys.store.GetBlock(name)
+	if err != nil {
+		return nil, mountError(err)
+	}
+
+	return &mountFile{
+		filesys: dir.filesys,
+		blobs:   block.Blobs,
+		size:    block.Size,
+		mode:    0444,
+		mtime:   time.Unix(block.Timestamp, 0),
+	}, nil
+}
+
+type mountSnapshots struct {
+	filesys *mountFS
+}
+
+func (dir *mountSnapshots) Attr(_ context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeDir | 0555
+	return nil
+}
+
+func (dir *mountSnapshots) ReadDirAll(_ context.Context) ([]fuse.Dirent, error) {
+	ids, err := dir.filesys.store.ListSnapshots()
+	if err != nil {
+		return nil, mountError(err)
+	}
+
+	entries := make([]fuse.Dirent, 0, len(ids))
+	for _, id := range ids {
+		entries = append(entries, fuse.Dirent{Name: id, Type: fuse.DT_Dir})
+	}
+
+	return entries, nil
+}
+
+func (dir *mountSnapshots) Lookup(_ context.Context, name string) (fs.Node, error) {
+	snapshot, err := dir.filesys.store.GetSnapshot(name)
+	if err != nil {
+		return nil, mountError(err)
+	}
+
+	return &mountTree{
+		filesys: dir.filesys,
+		id:      snapshot.Tree,
+		mode:    0555,
+		mtime:   time.Unix(snapshot.Timestamp, 0),
+	}, nil
+}
+
+// mountTree is a directory of a snapshot, its tree is loaded on first use.
+type mountTree struct {
+	filesys *mountFS
+	id      string
+	mode    os.FileMode
+	uid     uint32
+	gid     uint32
+	mtime   time.Time
+}
+
+func (dir *mountTree) Attr(_ context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeDir | dir.mode
+	attr.Uid = dir.uid
+	attr.Gid = dir.gid
+	attr.Mtime = dir.mtime
+
+	return nil
+}
+
+func (dir *mountTree) ReadDirAll(_ context.Context) ([]fuse.Dirent, error) {
+	tree, err := dir.filesys.store.GetTree(dir.id)
+	if err != nil {
+		return nil, mountError(err)
+	}
+
+	entries := make([]fuse.Dirent, 0, len(tree.Nodes))
+
+	for _, node := range tree.Nodes {
+		entry := fuse.Dirent{Name: node.Name, Type: fuse.DT_Unknown}
+
+		switch node.Type {
+		case storage.NodeTypeFile:
+			entry.Type = fuse.DT_File
+		case storage.NodeTypeDir:
+			entry.Type = fuse.DT_Dir
+		case storage.NodeTypeSymlink:
+			entry.Type = fuse.DT_Link
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (dir *mountTree) Lookup(_ context.Context, name string) (fs.Node, error) {
+	tree, err := dir.filesys.store.GetTree(dir.id)
+	if err != nil {
+		return nil, mountError(err)
+	}
+
+	for _, node := range tree.Nodes {
+		if node.Name != name {
+			continue
+		}
+
+		mode := os.FileMode(node.Mode).Perm()
+		mtime := time.Unix(0, node.ModTime)
+
+		switch node.Type {
+		case storage.NodeTypeDir:
+			return &mountTree{
+				filesys: dir.filesys,
+				id:      node.Subtree,
+				mode:    mode,
+				uid:     node.UID,
+				gid:     node.GID,
+				mtime:   mtime,
+			}, nil
+		case storage.NodeTypeSymlink:
+			return &mountSymlink{
+				target: node.LinkTarget,
+				uid:    node.UID,
+				gid:    node.GID,
+				mtime:  mtime,
+			}, nil
+		}
+
+		return &mountFile{
+			filesys: dir.filesys,
+			blobs:   node.Content,
+			size:    node.Size,
+			mode:    mode,
+			uid:     node.UID,
+			gid:     node.GID,
+			mtime:   mtime,
+		}, nil
+	}
+
+	return nil, fuse.ENOENT
+}
+
+type mountFile struct {
+	filesys *mountFS
+	blobs   []storage.Blob
+	size    uint64
+	mode    os.FileMode
+	uid     uint32
+	gid     uint32
+	mtime   time.Time
+}
+
+func (file *mountFile) Attr(_ context.Context, attr *fuse.Attr) error {
+	attr.Mode = file.mode
+	attr.Size = file.size
+	attr.Uid = file.uid
+	attr.Gid = file.gid
+	attr.Mtime = file.mtime
+
+	return nil
+}
+
+func (file *mountFile) Read(_ context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+
+	n, err := file.filesys.cache.ReadAt(file.blobs, buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return mountError(err)
+	}
+
+	resp.Data = buf[:n]
+
+	return nil
+}
+
+type mountSymlink struct {
+	target string
+	uid    uint32
+	gid    uint32
+	mtime  time.Time
+}
+
+func (link *mountSymlink) Attr(_ context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeSymlink | 0777
+	attr.Size = uint64(len(link.target))
+	attr.Uid = link.uid
+	attr.Gid = link.gid
+	attr.Mtime = link.mtime
+
+	return nil
+}
+
+func (link *mountSymlink) Readlink(_ context.Context, _ *fuse.ReadlinkRequest) (string, error) {
+	return link.target, nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package cmd
+
+import (
+	"errors"
+
+	"github.com/urfave/cli/v2"
+)
+
+func newMountCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "mount",
+		Usage:     "mount blocks and snapshots as a read-only filesystem",
+		ArgsUsage: "MOUNTPOINT",
+		Action: func(c *cli.Context) error {
+			return errors.New("mount is only supported on linux")
+		},
+	}
+}
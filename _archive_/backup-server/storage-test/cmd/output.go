@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var jsonFlag = &cli.BoolFlag{
+	Name:  "json",
+	Value: false,
+	Usage: "print output as json",
+}
+
+func printJSON(data interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(data)
+}
+
+func newTable(writer io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+}
+
+func formatTime(timestamp int64) string {
+	return time.Unix(timestamp, 0).Local().Format("2006-01-02 15:04:05")
+}
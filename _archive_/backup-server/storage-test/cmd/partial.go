@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+type partialEntry struct {
+	ID        string `json:"id"`
+	Source    string `json:"source"`
+	Timestamp int64  `json:"timestamp"`
+	Updated   int64  `json:"updated"`
+	Size      uint64 `json:"size"`
+}
+
+func newPartialCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "partial",
+		Usage: "manage checkpoints of interrupted backups",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "list interrupted backups",
+				Flags: []cli.Flag{
+					jsonFlag,
+				},
+				Action: listPartial,
+			},
+			{
+				Name:      "remove",
+				Usage:     "remove checkpoints, their chunks are freed by the next prune",
+				ArgsUsage: "[BLOCK-ID...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all",
+						Value: false,
+						Usage: "remove all checkpoints",
+					},
+				},
+				Action: removePartial,
+			},
+		},
+	}
+}
+
+func listPartial(c *cli.Context) (err error) {
+	store, err := openStorage(c, false, storage.LockShared)
+	if err != nil {
+		return err
+	}
+
+	defer closeStorage(store, &err)
+
+	ids, err := store.ListCheckpoints()
+	if err != nil {
+		return err
+	}
+
+	entries := []partialEntry{}
+
+	for _, id := range ids {
+		checkpoint, err := store.GetCheckpoint(id)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, partialEntry{
+			ID:        checkpoint.ID,
+			Source:    checkpoint.Source,
+			Timestamp: checkpoint.Timestamp,
+			Updated:   checkpoint.Updated,
+			Size:      checkpoint.Size,
+		})
+	}
+
+	if c.Bool("json") {
+		return printJSON(entries)
+	}
+
+	table := newTable(os.Stdout)
+
+	fmt.Fprintln(table, "ID\tSTARTED\tUPDATED\tWRITTEN\tSOURCE")
+
+	for _, entry := range entries {
+		fmt.Fprintf(
+			table, "%s\t%s\t%s\t%s\t%s\n",
+			entry.ID, formatTime(entry.Timestamp), formatTime(entry.Updated),
+			humanize.Bytes(entry.Size), entry.Source,
+		)
+	}
+
+	return table.Flush()
+}
+
+func removePartial(c *cli.Context) (err error) {
+	if c.NArg() == 0 && !c.Bool("all") {
+		return errors.New("block id or --all required")
+	}
+
+	// an exclusive lock keeps running backups from losing their checkpoint
+	store, err := openStorage(c, false, storage.LockExclusive)
+	if err != nil {
+		return err
+	}
+
+	defer closeStorage(store, &err)
+
+	ids := c.Args().Slice()
+
+	if c.Bool("all") {
+		if ids, err = store.ListCheckpoints(); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		if err := store.DeleteCheckpoint(id); err != nil {
+			return err
+		}
+
+		log.Printf("removed checkpoint %s", id)
+	}
+
+	return nil
+}
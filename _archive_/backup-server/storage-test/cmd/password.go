@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+func readPassword(c *cli.Context) (string, error) {
+	return promptPassword(c, "password", "password-file", "enter repository password: ")
+}
+
+// promptPassword reads the password from the given flags or asks for it.
+func promptPassword(c *cli.Context, passwordFlag, fileFlag, prompt string) (string, error) {
+	if len(c.String(passwordFlag)) > 0 {
+		return c.String(passwordFlag), nil
+	}
+
+	if len(c.String(fileFlag)) > 0 {
+		data, err := os.ReadFile(c.String(fileFlag))
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	return readTerminalPassword(prompt)
+}
+
+// confirmPassword asks for the password of a new repository a second time,
+// unless it was given by the flags, so a typo does not lock out the user.
+func confirmPassword(c *cli.Context, passwordFlag, fileFlag, password string) error {
+	if len(c.String(passwordFlag)) > 0 || len(c.String(fileFlag)) > 0 {
+		return nil
+	}
+
+	again, err := readTerminalPassword("enter the password again: ")
+	if err != nil {
+		return err
+	}
+
+	if again != password {
+		return errors.New("passwords do not match")
+	}
+
+	return nil
+}
+
+func readTerminalPassword(prompt string) (string, error) {
+	// stdin may carry the backup stream, so ask on the terminal directly
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("no password given and no terminal to prompt on")
+	}
+
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+
+	password, err := term.ReadPassword(int(tty.Fd()))
+
+	fmt.Fprintln(tty)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(password), nil
+}
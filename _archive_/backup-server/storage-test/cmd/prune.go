@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newPruneCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "prune",
+		Usage: "remove chunks not referenced by any block or snapshot",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Value: false,
+				Usage: "only report reclaimable space",
+			},
+		},
+		Action: func(c *cli.Context) (err error) {
+			store, err := openStorage(c, false, storage.LockExclusive)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			return runPrune(store, c.Bool("dry-run"))
+		},
+	}
+}
+
+func runPrune(store *storage.Storage, dryRun bool) error {
+	report, err := store.Prune(dryRun)
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"chunks: %d (%s), unreferenced: %d (%s), packs removed: %d, packs repacked: %d, loose removed: %d",
+		report.TotalChunks, humanize.Bytes(report.TotalBytes),
+		report.UnreferencedChunks, humanize.Bytes(report.UnreferencedBytes),
+		report.RemovedPacks, report.RepackedPacks, report.RemovedLoose,
+	)
+
+	if dryRun {
+		log.Printf("reclaimable: %s (dry run, nothing removed)", humanize.Bytes(report.UnreferencedBytes))
+	}
+
+	return nil
+}
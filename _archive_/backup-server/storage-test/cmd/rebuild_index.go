@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newRebuildIndexCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rebuild-index",
+		Usage: "rebuild chunk index from stored chunks",
+		Action: func(c *cli.Context) (err error) {
+			store, err := openStorage(c, false, storage.LockExclusive)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			count, err := store.RebuildIndex()
+			if err != nil {
+				return err
+			}
+
+			log.Printf("index rebuilt: %d chunks", count)
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+// backupEvent is a json line printed by backup --json.
+type backupEvent struct {
+	Type string `json:"type"`
+	Time string `json:"time"`
+	storage.WriteStats
+	TotalBytes       int64   `json:"total_bytes,omitempty"`
+	Throughput       float64 `json:"throughput"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	Error            string  `json:"error,omitempty"`
+}
+
+// backupReport shows the progress of a backup either as bar or as json
+// progress events and exports the result.
+type backupReport struct {
+	c         *cli.Context
+	encoder   *json.Encoder
+	bar       *pb.ProgressBar
+	total     int64
+	interval  time.Duration
+	lastEvent time.Time
+}
+
+func newBackupReport(c *cli.Context) *backupReport {
+	report := &backupReport{
+		c:        c,
+		interval: c.Duration("progress-interval"),
+	}
+
+	if c.Bool("json") {
+		report.encoder = json.NewEncoder(os.Stdout)
+	}
+
+	return report
+}
+
+// start begins the report of total bytes, zero if unknown.
+func (report *backupReport) start(total int64) {
+	report.total = total
+
+	if report.encoder == nil {
+		report.bar = pb.Full.Start64(total)
+	}
+}
+
+func (report *backupReport) setCurrent(offset int64) {
+	if report.bar != nil {
+		report.bar.SetCurrent(offset)
+	}
+}
+
+func (report *backupReport) reader(reader io.Reader) io.Reader {
+	if report.bar == nil {
+		return reader
+	}
+
+	return report.bar.NewProxyReader(reader)
+}
+
+func (report *backupReport) progress(stats storage.WriteStats) {
+	if report.encoder == nil || time.Since(report.lastEvent) < report.interval {
+		return
+	}
+
+	report.lastEvent = time.Now()
+
+	report.emit("progress", &stats, nil)
+}
+
+func (report *backupReport) emit(eventType string, stats *storage.WriteStats, err error) {
+	event := backupEvent{
+		Type:       eventType,
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		TotalBytes: report.total,
+	}
+
+	if stats != nil {
+		event.WriteStats = *stats
+		event.Throughput = stats.Throughput()
+		event.CompressionRatio = stats.CompressionRatio()
+	}
+
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	if encodeErr := report.encoder.Encode(event); encodeErr != nil {
+		log.Printf("progress: %s", encodeErr)
+	}
+}
+
+// finish prints the result of the backup and exports its metrics, the
+// backup error is returned.
+func (report *backupReport) finish(stats *storage.WriteStats, err error) error {
+	if report.bar != nil {
+		report.bar.Finish()
+	}
+
+	switch {
+	case report.encoder != nil && err != nil:
+		report.emit("error", stats, err)
+
+	case report.encoder != nil:
+		report.emit("summary", stats, nil)
+
+	case err == nil && len(stats.SnapshotID) > 0:
+		log.Printf("%s\nSnapshot ID: %s\n", stats, stats.SnapshotID)
+
+	case err == nil:
+		log.Printf("%s\nBlock ID: %s\n", stats, stats.BlockID)
+	}
+
+	if exportErr := exportMetrics(report.c, stats, err); exportErr != nil {
+		if err != nil {
+			log.Printf("export metrics: %s", exportErr)
+			return err
+		}
+
+		return fmt.Errorf("export metrics: %w", exportErr)
+	}
+
+	return err
+}
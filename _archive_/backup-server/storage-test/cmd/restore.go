@@ -1,7 +1,8 @@
 package cmd
 
 import (
-	"log"
+	"errors"
+	"io"
 	"os"
 
 	"github.com/cheggaaa/pb/v3"
@@ -12,46 +13,105 @@ import (
 func newRestoreCommand() *cli.Command {
 	return &cli.Command{
 		Name: "restore",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:     "block-id",
 				Usage:    "block id",
 				Required: true,
 			},
 			&cli.StringFlag{
-				Name:     "output-file",
-				Required: true,
+				Name:  "output-file",
+				Usage: "path to output file",
 			},
-		},
-		Action: func(c *cli.Context) error {
-			store := storage.New(storage.StorageConfig{
-				Path: c.String("storage-path"),
-			})
+			&cli.BoolFlag{
+				Name:  "stdout",
+				Value: false,
+				Usage: "write restored data to stdout",
+			},
+			&cli.StringFlag{
+				Name:  "member",
+				Usage: "restore only this member of an archive block",
+			},
+			&cli.IntFlag{
+				Name:  "prefetch",
+				Value: 4,
+				Usage: "number of chunks read ahead in parallel",
+			},
+			&cli.BoolFlag{
+				Name:  "sparse",
+				Value: true,
+				Usage: "leave holes in --output-file instead of writing all-zero chunks",
+			},
+		}, append(throttleFlags(), cacheFlags()...)...),
+		Action: func(c *cli.Context) (err error) {
+			if c.Bool("stdout") == (len(c.String("output-file")) > 0) {
+				return errors.New("either --output-file or --stdout is required")
+			}
+
+			store, err := openStorage(c, false, storage.LockShared)
+			if err != nil {
+				return err
+			}
 
-			block := store.GetBlock(c.String("block-id"))
+			defer closeStorage(store, &err)
 
-			file, err := os.Create(c.String("output-file"))
+			block, err := store.GetBlock(c.String("block-id"))
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 
-			defer file.Close()
+			cache, err := newChunkCache(c, store)
+			if err != nil {
+				return err
+			}
 
-			bar := pb.Full.Start64(int64(block.Size))
+			opts := storage.RestoreOptions{
+				Prefetch: c.Int("prefetch"),
+				Cache:    cache,
+			}
 
-			defer bar.Finish()
+			var writer io.Writer = os.Stdout
 
-			for _, blob := range block.Blobs {
-				data := store.GetChunk(blob.ID)
+			if !c.Bool("stdout") {
+				file, err := os.Create(c.String("output-file"))
+				if err != nil {
+					return err
+				}
+
+				defer file.Close()
+
+				writer = file
+				opts.Sparse = c.Bool("sparse")
+			}
+
+			member := c.String("member")
+
+			var bar *pb.ProgressBar
+
+			if len(member) == 0 {
+				// the bar writes to stderr, so it does not mix with --stdout
+				bar = pb.Full.Start64(int64(block.Size))
 
-				bar.Add64(int64(blob.Length))
+				defer bar.Finish()
 
-				if _, err := file.WriteAt(data, int64(blob.Offset)); err != nil {
-					log.Fatal(err)
+				// holes are not written, so the bar counts blobs
+				opts.Progress = func(n int) {
+					bar.Add(n)
 				}
 			}
 
-			return nil
+			stop, err := startThrottles(c, bar, store)
+			if err != nil {
+				return err
+			}
+
+			defer stop()
+
+			if len(member) > 0 {
+				return store.RestoreMember(writer, block, member, opts)
+			}
+
+			return store.RestoreStream(writer, block.Blobs, block.CheckSum, opts)
 		},
 	}
 }
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+type snapshotsEntry struct {
+	Type      string   `json:"type"`
+	ID        string   `json:"id"`
+	Timestamp int64    `json:"timestamp"`
+	Hostname  string   `json:"hostname,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Paths     []string `json:"paths,omitempty"`
+	Size      uint64   `json:"size"`
+	CheckSum  string   `json:"checksum,omitempty"`
+}
+
+func newSnapshotsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "snapshots",
+		Usage: "list blocks and snapshots",
+		Flags: []cli.Flag{
+			jsonFlag,
+		},
+		Action: func(c *cli.Context) (err error) {
+			store, err := openStorage(c, false, storage.LockShared)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			var entries []snapshotsEntry
+
+			blocks, err := store.ListBlocks()
+			if err != nil {
+				return err
+			}
+
+			for _, id := range blocks {
+				block, err := store.GetBlock(id)
+				if err != nil {
+					return err
+				}
+
+				entries = append(entries, snapshotsEntry{
+					Type:      "block",
+					ID:        block.ID,
+					Timestamp: block.Timestamp,
+					Size:      block.Size,
+					CheckSum:  block.CheckSum,
+				})
+			}
+
+			snapshots, err := store.ListSnapshots()
+			if err != nil {
+				return err
+			}
+
+			for _, id := range snapshots {
+				snapshot, err := store.GetSnapshot(id)
+				if err != nil {
+					return err
+				}
+
+				entries = append(entries, snapshotsEntry{
+					Type:      "snapshot",
+					ID:        snapshot.ID,
+					Timestamp: snapshot.Timestamp,
+					Hostname:  snapshot.Hostname,
+					Tags:      snapshot.Tags,
+					Paths:     snapshot.Paths,
+					Size:      snapshot.Size,
+				})
+			}
+
+			sort.SliceStable(entries, func(i, j int) bool {
+				return entries[i].Timestamp < entries[j].Timestamp
+			})
+
+			if c.Bool("json") {
+				return printJSON(entries)
+			}
+
+			table := newTable(os.Stdout)
+
+			fmt.Fprintln(table, "TYPE\tID\tTIME\tHOST\tTAGS\tSIZE\tCHECKSUM/PATHS")
+
+			for _, entry := range entries {
+				details := entry.CheckSum
+				if entry.Type == "snapshot" {
+					details = strings.Join(entry.Paths, ",")
+				}
+
+				fmt.Fprintf(
+					table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					entry.Type, entry.ID, formatTime(entry.Timestamp), entry.Hostname,
+					strings.Join(entry.Tags, ","), humanize.Bytes(entry.Size), details,
+				)
+			}
+
+			return table.Flush()
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newStatsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "stats",
+		Usage: "show chunk size histogram, dedup and compression ratios",
+		Flags: []cli.Flag{
+			jsonFlag,
+		},
+		Action: func(c *cli.Context) (err error) {
+			store, err := openStorage(c, false, storage.LockShared)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			stats, err := store.Stats()
+			if err != nil {
+				return err
+			}
+
+			if c.Bool("json") {
+				return printJSON(struct {
+					*storage.RepositoryStats
+					DedupRatio       float64 `json:"dedup_ratio"`
+					CompressionRatio float64 `json:"compression_ratio"`
+				}{stats, stats.DedupRatio(), stats.CompressionRatio()})
+			}
+
+			table := newTable(os.Stdout)
+			fmt.Fprintln(table, "CHUNK SIZE\tCHUNKS\tBYTES")
+
+			for _, bucket := range stats.Histogram {
+				fmt.Fprintf(table, "< %s\t%d\t%s\n", humanize.IBytes(bucket.MaxSize), bucket.Chunks, humanize.IBytes(bucket.Bytes))
+			}
+
+			if err := table.Flush(); err != nil {
+				return err
+			}
+
+			log.Printf(
+				"blocks: %d, snapshots: %d, total: %s in %d chunks, unique: %s in %d chunks, stored: %s",
+				stats.Blocks, stats.Snapshots,
+				humanize.Bytes(stats.TotalBytes), stats.TotalChunks,
+				humanize.Bytes(stats.UniqueBytes), stats.UniqueChunks,
+				humanize.Bytes(stats.StoredBytes),
+			)
+
+			if stats.MissingChunks > 0 {
+				log.Printf("missing: %s in %d chunks, run check for details", humanize.Bytes(stats.MissingBytes), stats.MissingChunks)
+			}
+
+			log.Printf("dedup ratio: %.2f, compression ratio: %.2f", stats.DedupRatio(), stats.CompressionRatio())
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+// storageLocation returns the backend location, --server is used instead of
+// --storage-path.
+func storageLocation(c *cli.Context) (string, error) {
+	server := c.String("server")
+	if len(server) == 0 {
+		return c.String("storage-path"), nil
+	}
+
+	uri, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+
+	if uri.Scheme != "http" && uri.Scheme != "https" {
+		return "", fmt.Errorf("unsupported server url: %s", uri.Redacted())
+	}
+
+	return uri.String(), nil
+}
+
+// openDestination opens the --to repository, a missing one is created with
+// the chunk ID key and hash of source, or with defaults when source is nil.
+func openDestination(c *cli.Context, source *storage.Storage) (*storage.Storage, error) {
+	password, err := promptPassword(c, "to-password", "to-password-file", "enter destination repository password: ")
+	if err != nil {
+		return nil, err
+	}
+
+	conf := storage.StorageConfig{
+		Path:     c.String("to"),
+		Password: password,
+		Lock:     storage.LockShared,
+		Source:   source,
+	}
+
+	dst, err := storage.New(conf)
+	if errors.Is(err, storage.ErrNotInitialized) {
+		if err := confirmPassword(c, "to-password", "to-password-file", password); err != nil {
+			return nil, err
+		}
+
+		conf.Create = true
+		dst, err = storage.New(conf)
+	}
+
+	return dst, err
+}
+
+// closeStorage closes the repository in a deferred call, the close error is
+// returned unless the command failed before.
+func closeStorage(store *storage.Storage, err *error) {
+	if closeErr := store.Close(); *err == nil {
+		*err = closeErr
+	}
+}
+
+// openStorage opens the repository and takes the lock, --no-lock skips
+// shared locks.
+func openStorage(c *cli.Context, discard bool, lock storage.LockMode) (*storage.Storage, error) {
+	conf, err := storageConfig(c, discard)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.Lock = lock
+
+	if lock == storage.LockShared && c.Bool("no-lock") {
+		conf.Lock = storage.LockNone
+	}
+
+	return storage.New(conf)
+}
+
+func storageConfig(c *cli.Context, discard bool) (storage.StorageConfig, error) {
+	var password string
+
+	if !discard {
+		var err error
+
+		password, err = readPassword(c)
+		if err != nil {
+			return storage.StorageConfig{}, err
+		}
+	}
+
+	location, err := storageLocation(c)
+	if err != nil {
+		return storage.StorageConfig{}, err
+	}
+
+	conf := storage.StorageConfig{
+		Discard:   discard,
+		Path:      location,
+		Password:  password,
+		Token:     c.String("server-token"),
+		Workers:   c.Int("workers"),
+		Uploaders: c.Int("upload-workers"),
+	}
+
+	if maxMemory := c.String("max-memory"); len(maxMemory) > 0 {
+		size, err := humanize.ParseBytes(maxMemory)
+		if err != nil {
+			return storage.StorageConfig{}, err
+		}
+
+		conf.MaxMemory = int64(size)
+	}
+
+	if conf.ReadLimit, conf.WriteLimit, err = newThrottles(c); err != nil {
+		return storage.StorageConfig{}, err
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func throttleFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "limit-read",
+			Usage: "limit read bandwidth per second, e.g. 50MB",
+		},
+		&cli.Int64Flag{
+			Name:  "limit-read-ops",
+			Usage: "limit repository read requests per second",
+		},
+		&cli.StringFlag{
+			Name:  "limit-write",
+			Usage: "limit write bandwidth per second, e.g. 50MB",
+		},
+		&cli.Int64Flag{
+			Name:  "limit-write-ops",
+			Usage: "limit repository write and delete requests per second",
+		},
+		&cli.StringFlag{
+			Name:  "limit-socket",
+			Usage: "unix socket accepting limit changes while running, e.g. \"read 10MB 100\"",
+		},
+	}
+}
+
+// newThrottles returns the read and write throttles for the limit flags,
+// both are nil when no limit and no control socket is given.
+func newThrottles(c *cli.Context) (read, write *storage.Throttle, err error) {
+	readBytes, err := parseRate(c.String("limit-read"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	writeBytes, err := parseRate(c.String("limit-write"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	readOps := c.Int64("limit-read-ops")
+	writeOps := c.Int64("limit-write-ops")
+
+	if readBytes == 0 && writeBytes == 0 && readOps == 0 && writeOps == 0 && len(c.String("limit-socket")) == 0 {
+		return nil, nil, nil
+	}
+
+	return storage.NewThrottle(readBytes, readOps), storage.NewThrottle(writeBytes, writeOps), nil
+}
+
+func parseRate(value string) (int64, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	size, err := humanize.ParseBytes(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(size), nil
+}
+
+func describeThrottle(throttle *storage.Throttle) string {
+	bytesPerSec, opsPerSec := throttle.Limits()
+
+	var limits []string
+
+	if bytesPerSec > 0 {
+		limits = append(limits, humanize.Bytes(uint64(bytesPerSec))+"/s")
+	}
+
+	if opsPerSec > 0 {
+		limits = append(limits, fmt.Sprintf("%d ops/s", opsPerSec))
+	}
+
+	if len(limits) == 0 {
+		return "unlimited"
+	}
+
+	return strings.Join(limits, ", ")
+}
+
+func describeThrottles(read, write *storage.Throttle) string {
+	return fmt.Sprintf("read %s, write %s", describeThrottle(read), describeThrottle(write))
+}
+
+// showThrottles puts the current limits next to the rate shown by the bar.
+func showThrottles(bar *pb.ProgressBar, read, write *storage.Throttle) {
+	if bar == nil || (read == nil && write == nil) {
+		return
+	}
+
+	bar.Set("suffix", " ("+describeThrottles(read, write)+")")
+}
+
+// startThrottles shows the limits on the bar and serves the control socket,
+// the returned func stops serving.
+func startThrottles(c *cli.Context, bar *pb.ProgressBar, store *storage.Storage) (func(), error) {
+	read, write := store.Throttles()
+
+	showThrottles(bar, read, write)
+
+	return serveThrottles(c, bar, read, write)
+}
+
+// serveThrottles listens on --limit-socket for limit changes. Every line is
+// a command: "read|write BYTES [OPS]" sets the limits of a direction, 0
+// removes a limit, "show" prints the current limits.
+func serveThrottles(c *cli.Context, bar *pb.ProgressBar, read, write *storage.Throttle) (func(), error) {
+	path := c.String("limit-socket")
+	if len(path) == 0 || read == nil || write == nil {
+		return func() {}, nil
+	}
+
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				scanner := bufio.NewScanner(conn)
+
+				for scanner.Scan() {
+					if err := applyThrottleCommand(scanner.Text(), read, write); err != nil {
+						fmt.Fprintf(conn, "error: %s\n", err)
+						continue
+					}
+
+					showThrottles(bar, read, write)
+
+					fmt.Fprintln(conn, describeThrottles(read, write))
+				}
+			}()
+		}
+	}()
+
+	return func() {
+		listener.Close()
+		os.Remove(path)
+	}, nil
+}
+
+// listenPrivate listens on a unix socket at path that only the user can
+// connect to, anyone able to connect can change the limits. The socket is
+// created in a private directory and linked to path once its mode is set,
+// so it is never reachable with the default mode.
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".limit-socket-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "socket")
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: name, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	// the socket lives on at path, the stop func removes it
+	listener.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(name, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	if err := os.Link(name, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+func applyThrottleCommand(line string, read, write *storage.Throttle) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] == "show" {
+		return nil
+	}
+
+	var throttle *storage.Throttle
+
+	switch fields[0] {
+	case "read":
+		throttle = read
+	case "write":
+		throttle = write
+	default:
+		return fmt.Errorf("unknown command: %s", fields[0])
+	}
+
+	if len(fields) < 2 || len(fields) > 3 {
+		return errors.New("usage: read|write BYTES [OPS]")
+	}
+
+	bytesPerSec, err := parseRate(fields[1])
+	if err != nil {
+		return err
+	}
+
+	_, opsPerSec := throttle.Limits()
+
+	if len(fields) == 3 {
+		if opsPerSec, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return err
+		}
+	}
+
+	throttle.SetLimits(bytesPerSec, opsPerSec)
+
+	log.Printf("limits changed: %s", describeThrottles(read, write))
+
+	return nil
+}
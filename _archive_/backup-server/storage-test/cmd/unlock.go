@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vitalvas/backup-server/storage-test/storage"
+)
+
+func newUnlockCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "unlock",
+		Usage: "remove stale repository locks",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "remove-all",
+				Value: false,
+				Usage: "remove all locks, even those of running processes",
+			},
+		},
+		Action: func(c *cli.Context) (err error) {
+			store, err := openStorage(c, false, storage.LockNone)
+			if err != nil {
+				return err
+			}
+
+			defer closeStorage(store, &err)
+
+			removed, err := store.RemoveLocks(c.Bool("remove-all"))
+			if err != nil {
+				return err
+			}
+
+			log.Printf("removed %d locks", removed)
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,675 @@
+package storage
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	ArchiveTar  = "tar"
+	ArchiveCpio = "cpio"
+
+	// MemberTypeHardlink is a member referring to another member by name.
+	MemberTypeHardlink = "hardlink"
+
+	tarBlockSize = 512
+	tarPeekSize  = 64 << 10
+)
+
+// Member is a file inside an archive block. Headers and content of every
+// member are separate segments of the block stream, chunked on their own,
+// so a member can be restored from the blobs of its byte range.
+type Member struct {
+	Name       string
+	Type       string
+	Mode       uint32
+	UID        uint32
+	GID        uint32
+	ModTime    int64
+	Size       uint64
+	LinkTarget string `json:",omitempty"`
+	// HeaderOffset is the position of the first header of the member in
+	// the stream, Offset and Length the stored content.
+	HeaderOffset uint64
+	Offset       uint64
+	Length       uint64
+}
+
+var errTruncatedArchive = errors.New("truncated archive")
+
+func validateArchiveFormat(format string) error {
+	switch format {
+	case ArchiveTar, ArchiveCpio:
+		return nil
+	}
+
+	return fmt.Errorf("unknown archive format: %s", format)
+}
+
+// archiveWriter splits an archive stream into header and content segments.
+// Header bytes are collected until the content of their member starts.
+type archiveWriter struct {
+	storage *Storage
+	st      *stream
+	reader  *bufio.Reader
+	buf     []byte
+	stats   *writeStats
+	header  bytes.Buffer
+	members []Member
+}
+
+// writeArchive appends the archive to the stream and returns its members.
+func (storage *Storage) writeArchive(st *stream, reader io.Reader, format string, stats *writeStats) ([]Member, error) {
+	archive := &archiveWriter{
+		storage: storage,
+		st:      st,
+		reader:  bufio.NewReaderSize(reader, 1<<20),
+		buf:     make([]byte, 4*storage.config.Chunker.MaxSize),
+		stats:   stats,
+	}
+
+	var err error
+
+	switch format {
+	case ArchiveTar:
+		err = archive.writeTar()
+	case ArchiveCpio:
+		err = archive.writeCpio()
+	default:
+		err = validateArchiveFormat(format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return archive.members, nil
+}
+
+// readHeader reads size bytes into the pending header.
+func (archive *archiveWriter) readHeader(size int64) ([]byte, error) {
+	start := archive.header.Len()
+
+	if _, err := io.CopyN(&archive.header, archive.reader, size); err != nil {
+		if err == io.EOF {
+			err = errTruncatedArchive
+		}
+
+		return nil, err
+	}
+
+	return archive.header.Bytes()[start:], nil
+}
+
+// writeMember stores the pending header and the content of the member,
+// padding is stored with the content, so the next header starts a segment.
+func (archive *archiveWriter) writeMember(member Member, padding uint64) error {
+	member.HeaderOffset = archive.st.size
+
+	if err := archive.writeBytes(archive.header.Bytes()); err != nil {
+		return err
+	}
+
+	archive.header.Reset()
+
+	member.Offset = archive.st.size
+
+	if err := archive.writeSegment(member.Length + padding); err != nil {
+		return err
+	}
+
+	archive.members = append(archive.members, member)
+
+	return nil
+}
+
+// writeTrailer stores the pending header and everything after it.
+func (archive *archiveWriter) writeTrailer() error {
+	if err := archive.writeBytes(archive.header.Bytes()); err != nil {
+		return err
+	}
+
+	archive.header.Reset()
+
+	return archive.storage.writeStreamTo(archive.st, archive.reader, archive.buf, archive.stats)
+}
+
+// writeSegment chunks the next size bytes of the input on their own.
+func (archive *archiveWriter) writeSegment(size uint64) error {
+	if size <= uint64(archive.storage.config.Chunker.MinSize) {
+		data := archive.buf[:size]
+
+		if _, err := io.ReadFull(archive.reader, data); err != nil {
+			return errTruncatedArchive
+		}
+
+		return archive.writeBytes(data)
+	}
+
+	start := archive.st.size
+
+	if err := archive.storage.writeStreamTo(archive.st, io.LimitReader(archive.reader, int64(size)), archive.buf, archive.stats); err != nil {
+		return err
+	}
+
+	if archive.st.size-start != size {
+		return errTruncatedArchive
+	}
+
+	return nil
+}
+
+// writeBytes appends data to the stream, segments below the minimum chunk
+// size are a single chunk.
+func (archive *archiveWriter) writeBytes(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if uint(len(data)) > archive.storage.config.Chunker.MinSize {
+		return archive.storage.writeStreamTo(archive.st, bytes.NewReader(data), archive.buf, archive.stats)
+	}
+
+	isWrited, chunkID, stored, err := archive.storage.writeChunk(data)
+	if err != nil {
+		return err
+	}
+
+	st := archive.st
+
+	st.checksum.Write(data)
+
+	st.blobs = append(st.blobs, Blob{
+		ID:     chunkID,
+		Offset: uint(st.size),
+		Length: uint(len(data)),
+	})
+
+	st.size += uint64(len(data))
+
+	archive.stats.addChunk(uint64(len(data)), isWrited, stored)
+
+	return st.maybeCheckpoint()
+}
+
+func (archive *archiveWriter) writeTar() error {
+	for {
+		block, err := archive.readHeader(tarBlockSize)
+		if err != nil {
+			// an archive without end marker is accepted
+			if err == errTruncatedArchive && archive.header.Len() == 0 {
+				return nil
+			}
+
+			return err
+		}
+
+		if isZeroBlock(block) {
+			return archive.writeTrailer()
+		}
+
+		size, err := parseTarNumber(block[124:136])
+		if err != nil {
+			return err
+		}
+
+		padded := (size + tarBlockSize - 1) &^ (tarBlockSize - 1)
+
+		switch block[156] {
+		case tar.TypeXHeader, tar.TypeXGlobalHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+			// extended headers belong to the next member
+			if _, err := archive.readHeader(padded); err != nil {
+				return err
+			}
+
+			continue
+
+		case tar.TypeGNUSparse:
+			// the sparse map continues in extension blocks
+			for extended := block[482] != 0; extended; {
+				ext, err := archive.readHeader(tarBlockSize)
+				if err != nil {
+					return err
+				}
+
+				extended = ext[504] != 0
+			}
+		}
+
+		// pax sparse files keep their map at the start of the content
+		content, _ := archive.reader.Peek(tarPeekSize)
+
+		header, err := lastTarHeader(io.MultiReader(bytes.NewReader(archive.header.Bytes()), bytes.NewReader(content)))
+		if err != nil {
+			return err
+		}
+
+		// the pax size record replaces the header field for large files
+		if value, ok := header.PAXRecords["size"]; ok {
+			if size, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return fmt.Errorf("invalid pax size: %s", value)
+			}
+
+			padded = (size + tarBlockSize - 1) &^ (tarBlockSize - 1)
+		}
+
+		member := Member{
+			Name:       header.Name,
+			Type:       tarMemberType(header.Typeflag),
+			Mode:       uint32(header.FileInfo().Mode()),
+			UID:        uint32(header.Uid),
+			GID:        uint32(header.Gid),
+			ModTime:    header.ModTime.UnixNano(),
+			Size:       uint64(header.Size),
+			LinkTarget: header.Linkname,
+			Length:     uint64(size),
+		}
+
+		if err := archive.writeMember(member, uint64(padded-size)); err != nil {
+			return err
+		}
+	}
+}
+
+// lastTarHeader decodes the member header, archive/tar merges the extended
+// headers in front of it, only global headers are returned on their own.
+func lastTarHeader(data io.Reader) (*tar.Header, error) {
+	reader := tar.NewReader(data)
+
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			return nil, fmt.Errorf("not a tar archive: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeXGlobalHeader {
+			return header, nil
+		}
+	}
+}
+
+func tarMemberType(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeCont, tar.TypeGNUSparse:
+		return NodeTypeFile
+	case tar.TypeDir:
+		return NodeTypeDir
+	case tar.TypeSymlink:
+		return NodeTypeSymlink
+	case tar.TypeLink:
+		return MemberTypeHardlink
+	}
+
+	return NodeTypeOther
+}
+
+// parseTarNumber decodes an octal header field or its base-256 variant.
+func parseTarNumber(field []byte) (int64, error) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		var value int64
+
+		for i, b := range field {
+			if i == 0 {
+				b &= 0x7f
+			}
+
+			if value > (1<<63-1)>>8 {
+				return 0, errors.New("not a tar archive: size overflow")
+			}
+
+			value = value<<8 | int64(b)
+		}
+
+		return value, nil
+	}
+
+	text := strings.Trim(string(field), " \x00")
+	if len(text) == 0 {
+		return 0, nil
+	}
+
+	value, err := strconv.ParseInt(text, 8, 64)
+	if err != nil {
+		return 0, errors.New("not a tar archive: invalid header")
+	}
+
+	return value, nil
+}
+
+func isZeroBlock(block []byte) bool {
+	for _, b := range block {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+const (
+	cpioNewcHeaderSize = 110
+	cpioOdcHeaderSize  = 76
+	cpioTrailer        = "TRAILER!!!"
+)
+
+// cpioHeader holds the fields of a newc or odc header used for members.
+type cpioHeader struct {
+	mode     uint32
+	uid      uint32
+	gid      uint32
+	mtime    int64
+	nameSize int64
+	fileSize int64
+	// align is the padding boundary of name and content, 1 for odc
+	align int64
+}
+
+func (archive *archiveWriter) writeCpio() error {
+	for {
+		magic, err := archive.readHeader(6)
+		if err != nil {
+			if err == errTruncatedArchive && archive.header.Len() == 0 {
+				return nil
+			}
+
+			return err
+		}
+
+		var header *cpioHeader
+
+		switch string(magic) {
+		case "070701", "070702":
+			data, err := archive.readHeader(cpioNewcHeaderSize - 6)
+			if err != nil {
+				return err
+			}
+
+			header, err = parseCpioNewc(data)
+			if err != nil {
+				return err
+			}
+
+		case "070707":
+			data, err := archive.readHeader(cpioOdcHeaderSize - 6)
+			if err != nil {
+				return err
+			}
+
+			header, err = parseCpioOdc(data)
+			if err != nil {
+				return err
+			}
+
+		default:
+			return errors.New("not a cpio archive: unsupported header")
+		}
+
+		// the name is padded together with the header
+		nameEnd := int64(archive.header.Len()) + header.nameSize
+
+		name, err := archive.readHeader(alignTo(nameEnd, header.align) - int64(archive.header.Len()))
+		if err != nil {
+			return err
+		}
+
+		name = bytes.TrimRight(name[:header.nameSize], "\x00")
+
+		if string(name) == cpioTrailer {
+			return archive.writeTrailer()
+		}
+
+		member := Member{
+			Name:    string(name),
+			Type:    cpioMemberType(header.mode),
+			Mode:    uint32(unixFileMode(header.mode)),
+			UID:     header.uid,
+			GID:     header.gid,
+			ModTime: header.mtime * 1e9,
+			Size:    uint64(header.fileSize),
+			Length:  uint64(header.fileSize),
+		}
+
+		padding := uint64(alignTo(header.fileSize, header.align) - header.fileSize)
+
+		if member.Type == NodeTypeSymlink {
+			// the link target is the content, keep it with the header
+			target, err := archive.readHeader(header.fileSize)
+			if err != nil {
+				return err
+			}
+
+			member.LinkTarget = string(target)
+
+			if _, err := archive.readHeader(int64(padding)); err != nil {
+				return err
+			}
+
+			member.Length = 0
+			padding = 0
+		}
+
+		if err := archive.writeMember(member, padding); err != nil {
+			return err
+		}
+	}
+}
+
+func parseCpioNewc(data []byte) (*cpioHeader, error) {
+	// ino mode uid gid nlink mtime filesize devmajor devminor rdevmajor
+	// rdevminor namesize check, each 8 hex digits
+	var fields [13]uint64
+
+	for i := range fields {
+		value, err := strconv.ParseUint(string(data[i*8:i*8+8]), 16, 32)
+		if err != nil {
+			return nil, errors.New("not a cpio archive: invalid header")
+		}
+
+		fields[i] = value
+	}
+
+	return &cpioHeader{
+		mode:     uint32(fields[1]),
+		uid:      uint32(fields[2]),
+		gid:      uint32(fields[3]),
+		mtime:    int64(fields[5]),
+		fileSize: int64(fields[6]),
+		nameSize: int64(fields[11]),
+		align:    4,
+	}, nil
+}
+
+func parseCpioOdc(data []byte) (*cpioHeader, error) {
+	// dev ino mode uid gid nlink rdev (6 digits) mtime (11) namesize (6)
+	// filesize (11), all octal
+	widths := []int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+
+	fields := make([]int64, len(widths))
+
+	pos := 0
+
+	for i, width := range widths {
+		value, err := strconv.ParseInt(string(data[pos:pos+width]), 8, 64)
+		if err != nil {
+			return nil, errors.New("not a cpio archive: invalid header")
+		}
+
+		fields[i] = value
+		pos += width
+	}
+
+	return &cpioHeader{
+		mode:     uint32(fields[2]),
+		uid:      uint32(fields[3]),
+		gid:      uint32(fields[4]),
+		mtime:    fields[7],
+		nameSize: fields[8],
+		fileSize: fields[9],
+		align:    1,
+	}, nil
+}
+
+func alignTo(size, align int64) int64 {
+	return (size + align - 1) / align * align
+}
+
+const (
+	unixTypeMask    = 0170000
+	unixTypeRegular = 0100000
+	unixTypeDir     = 0040000
+	unixTypeSymlink = 0120000
+)
+
+func cpioMemberType(mode uint32) string {
+	switch mode & unixTypeMask {
+	case unixTypeRegular:
+		return NodeTypeFile
+	case unixTypeDir:
+		return NodeTypeDir
+	case unixTypeSymlink:
+		return NodeTypeSymlink
+	}
+
+	return NodeTypeOther
+}
+
+// unixFileMode converts the mode of a cpio header to an os.FileMode.
+func unixFileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+
+	switch mode & unixTypeMask {
+	case unixTypeDir:
+		fileMode |= os.ModeDir
+	case unixTypeSymlink:
+		fileMode |= os.ModeSymlink
+	case unixTypeRegular:
+	default:
+		fileMode |= os.ModeIrregular
+	}
+
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+
+	return fileMode
+}
+
+// FindMember returns the member with the given name, leading "./" and "/"
+// are ignored.
+func (block *Block) FindMember(name string) (*Member, error) {
+	name = cleanMemberName(name)
+
+	for i := range block.Members {
+		if cleanMemberName(block.Members[i].Name) == name {
+			return &block.Members[i], nil
+		}
+	}
+
+	return nil, notFoundError("find member", name, errors.New("no such member"))
+}
+
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// RestoreMember writes the content of an archive member to writer, the
+// member is not restored sparse.
+func (storage *Storage) RestoreMember(writer io.Writer, block *Block, name string, opts RestoreOptions) error {
+	member, err := block.FindMember(name)
+	if err != nil {
+		return err
+	}
+
+	if member.Type != NodeTypeFile {
+		return fmt.Errorf("%s: not a regular file", member.Name)
+	}
+
+	// segments start at chunk boundaries, so the blobs of the member begin
+	// with its first header
+	var blobs []Blob
+
+	end := member.Offset + member.Length
+
+	for _, blob := range block.Blobs {
+		if uint64(blob.Offset) >= member.HeaderOffset && uint64(blob.Offset) < end {
+			blobs = append(blobs, blob)
+		}
+	}
+
+	reader, pipe := io.Pipe()
+
+	done := make(chan error, 1)
+
+	go func() {
+		err := storage.streamBlobs(pipe, blobs, RestoreOptions{Prefetch: opts.Prefetch, Cache: opts.Cache}, nil)
+		pipe.CloseWithError(err)
+		done <- err
+	}()
+
+	err = extractMember(writer, reader, block.Format, member)
+
+	reader.CloseWithError(io.ErrClosedPipe)
+
+	if streamErr := <-done; streamErr != nil && streamErr != io.ErrClosedPipe {
+		return streamErr
+	}
+
+	return err
+}
+
+// extractMember copies the member content from the stream starting at its
+// header. Tar members are decoded by archive/tar, which expands sparse files.
+func extractMember(writer io.Writer, reader io.Reader, format string, member *Member) error {
+	if format == ArchiveTar {
+		tarReader := tar.NewReader(reader)
+
+		// a global header in front of the member is returned on its own
+		for {
+			header, err := tarReader.Next()
+			if err != nil {
+				return corruptedError("extract member", member.Name, err)
+			}
+
+			if header.Typeflag != tar.TypeXGlobalHeader {
+				break
+			}
+		}
+
+		n, err := io.Copy(writer, tarReader)
+		if err != nil {
+			return err
+		}
+
+		if uint64(n) != member.Size {
+			return corruptedError("extract member", member.Name, fmt.Errorf("got %d of %d bytes", n, member.Size))
+		}
+
+		return nil
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, int64(member.Offset-member.HeaderOffset)); err != nil {
+		return corruptedError("extract member", member.Name, err)
+	}
+
+	if _, err := io.CopyN(writer, reader, int64(member.Length)); err != nil {
+		return corruptedError("extract member", member.Name, err)
+	}
+
+	return nil
+}
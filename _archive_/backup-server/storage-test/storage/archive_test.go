@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testMember struct {
+	name string
+	data []byte
+}
+
+var testMembers = []testMember{
+	{"small.txt", []byte("hello")},
+	{"empty", nil},
+	{"dir/large.bin", randomData(1, 300<<10)},
+	{"dir/" + strings.Repeat("long-name-", 12) + ".bin", randomData(2, 70<<10)},
+}
+
+func testTar(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	writer := tar.NewWriter(&buf)
+
+	if err := writer.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a global header lands in front of the first file
+	if err := writer.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		PAXRecords: map[string]string{"comment": "test archive"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, member := range testMembers {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     member.name,
+			Mode:     0644,
+			Size:     int64(len(member.data)),
+			ModTime:  time.Unix(1656633600, 0),
+			Format:   tar.FormatPAX,
+		}
+
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := writer.Write(member.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "small.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// testCpio builds a newc archive.
+func testCpio() []byte {
+	var buf bytes.Buffer
+
+	pad := func() {
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+
+	add := func(ino int, name string, mode uint32, data []byte) {
+		fmt.Fprintf(&buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			ino, mode, 0, 0, 1, 1656633600, len(data), 0, 0, 0, 0, len(name)+1, 0)
+		buf.WriteString(name)
+		buf.WriteByte(0)
+		pad()
+		buf.Write(data)
+		pad()
+	}
+
+	add(1, "dir", 0040755, nil)
+
+	for i, member := range testMembers {
+		add(i+2, member.name, 0100644, member.data)
+	}
+
+	add(0, "TRAILER!!!", 0, nil)
+
+	return buf.Bytes()
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, format := range []string{ArchiveTar, ArchiveCpio} {
+		t.Run(format, func(t *testing.T) {
+			store := newTestStorage(t, StorageConfig{})
+			defer store.Close()
+
+			input := testCpio()
+			if format == ArchiveTar {
+				input = testTar(t)
+			}
+
+			stats, err := store.Writer(bytes.NewReader(input), WriteOptions{Archive: format})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			block, err := store.GetBlock(stats.BlockID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := restoreTestBlock(t, store, block, RestoreOptions{}); !bytes.Equal(got, input) {
+				t.Fatal("restored archive differs")
+			}
+
+			for _, want := range testMembers {
+				member, err := block.FindMember(want.name)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if member.Size != uint64(len(want.data)) {
+					t.Fatalf("%s: size %d, want %d", want.name, member.Size, len(want.data))
+				}
+
+				var buf bytes.Buffer
+
+				if err := store.RestoreMember(&buf, block, want.name, RestoreOptions{}); err != nil {
+					t.Fatalf("%s: %v", want.name, err)
+				}
+
+				if !bytes.Equal(buf.Bytes(), want.data) {
+					t.Fatalf("%s: content differs", want.name)
+				}
+			}
+
+			if err := store.RestoreMember(&bytes.Buffer{}, block, "dir", RestoreOptions{}); err == nil {
+				t.Fatal("restored a directory")
+			}
+		})
+	}
+}
+
+func TestExtractMemberChecksSize(t *testing.T) {
+	member := &Member{Name: testMembers[0].name, Size: uint64(len(testMembers[0].data)) + 1}
+
+	var archive bytes.Buffer
+
+	writer := tar.NewWriter(&archive)
+	writer.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: member.Name, Size: int64(len(testMembers[0].data))})
+	writer.Write(testMembers[0].data)
+	writer.Close()
+
+	err := extractMember(&bytes.Buffer{}, &archive, ArchiveTar, member)
+	if !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("got %v, want ErrCorrupted", err)
+	}
+}
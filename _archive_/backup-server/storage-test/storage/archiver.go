@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+type archiver struct {
+	storage *Storage
+	opts    SnapshotOptions
+	buf     []byte
+	stats   *writeStats
+}
+
+// BackupPath walks the directory tree at root and stores it as a snapshot.
+func (storage *Storage) BackupPath(root string, opts SnapshotOptions) (*Snapshot, *WriteStats, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	arch := &archiver{
+		storage: storage,
+		opts:    opts,
+		buf:     make([]byte, 4*storage.config.Chunker.MaxSize),
+		stats:   newWriteStats(opts.Progress),
+	}
+
+	snapshot := NewSnapshot()
+	snapshot.Paths = []string{root}
+	snapshot.Hostname = opts.Hostname
+	snapshot.Tags = opts.Tags
+
+	snapshot.Tree, err = arch.archiveDir(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshot.Size = arch.stats.BytesRead
+
+	if err := storage.flushIndex(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := storage.writeSnapshot(snapshot); err != nil {
+		return nil, nil, err
+	}
+
+	stats := arch.stats.snapshot()
+	stats.SnapshotID = snapshot.ID
+
+	return snapshot, &stats, nil
+}
+
+func (arch *archiver) archiveDir(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	tree := &Tree{}
+
+	for _, entry := range entries {
+		node, err := arch.archiveNode(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return "", err
+		}
+
+		tree.Nodes = append(tree.Nodes, *node)
+	}
+
+	return arch.storage.writeTree(tree, arch.stats)
+}
+
+func (arch *archiver) archiveNode(name string) (*Node, error) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		Name:    info.Name(),
+		Mode:    uint32(info.Mode()),
+		ModTime: info.ModTime().UnixNano(),
+	}
+
+	fillNodeOwner(node, info)
+
+	if node.Xattrs, err = readXattrs(name); err != nil {
+		log.Printf("%s: xattrs: %s", name, err)
+	}
+
+	switch {
+	case info.Mode().IsRegular():
+		node.Type = NodeTypeFile
+
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		defer file.Close()
+
+		var reader io.Reader = file
+		if arch.opts.Reader != nil {
+			reader = arch.opts.Reader(file)
+		}
+
+		node.Content, node.Size, node.CheckSum, err = arch.storage.writeStream(reader, arch.buf, arch.stats)
+		if err != nil {
+			return nil, err
+		}
+
+	case info.IsDir():
+		node.Type = NodeTypeDir
+
+		if node.Subtree, err = arch.archiveDir(name); err != nil {
+			return nil, err
+		}
+
+	case info.Mode()&os.ModeSymlink != 0:
+		node.Type = NodeTypeSymlink
+
+		if node.LinkTarget, err = os.Readlink(name); err != nil {
+			return nil, err
+		}
+
+	default:
+		node.Type = NodeTypeOther
+	}
+
+	return node, nil
+}
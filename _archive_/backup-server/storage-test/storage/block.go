@@ -12,6 +12,15 @@ type Block struct {
 	Size      uint64
 	CheckSum  string
 	Timestamp int64
+	// Source names the input, blocks of the same source form a retention
+	// group.
+	Source string `json:",omitempty"`
+	// ChunkHash is the hash of the blob IDs and the checksum, see
+	// Config.ChunkHash.
+	ChunkHash string `json:",omitempty"`
+	// Format is the archive format of the stream, see WriteOptions.Archive.
+	Format  string   `json:",omitempty"`
+	Members []Member `json:",omitempty"`
 }
 
 type Blob struct {
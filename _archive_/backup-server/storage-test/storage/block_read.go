@@ -2,30 +2,43 @@ package storage
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
-	"os"
-	"path"
 
 	"github.com/klauspost/compress/s2"
 )
 
-func (storage *Storage) GetBlock(id string) (block *Block) {
-	filePath := path.Join(storage.path, "blocks", id[0:4], fmt.Sprintf("%s.dat", id))
+func (storage *Storage) GetBlock(id string) (block *Block, err error) {
+	if err := validateObjectID(id); err != nil {
+		return nil, notFoundError("read block", id, err)
+	}
+
+	if err := storage.readObject("read block", id, storage.blockPath(id), &block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// readObject loads an encrypted and compressed json object, like a block
+// manifest or a snapshot.
+func (storage *Storage) readObject(op, id, name string, value interface{}) error {
+	data, err := storage.backend.Get(name)
+	if err != nil {
+		return ioError(op, id, err)
+	}
 
-	data, err := os.ReadFile(filePath)
+	plaintext, err := open(storage.key.Encrypt, data)
 	if err != nil {
-		log.Fatal(err)
+		return corruptedError(op, id, err)
 	}
 
-	dst, err := s2.Decode(nil, data)
+	dst, err := s2.Decode(nil, plaintext)
 	if err != nil {
-		log.Fatal(err)
+		return corruptedError(op, id, err)
 	}
 
-	if err := json.Unmarshal(dst, &block); err != nil {
-		log.Fatal(err)
+	if err := json.Unmarshal(dst, value); err != nil {
+		return corruptedError(op, id, err)
 	}
 
-	return
+	return nil
 }
@@ -3,7 +3,6 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"path"
 
 	"github.com/klauspost/compress/s2"
@@ -15,17 +14,14 @@ func (storage *Storage) writeBlock(block *Block) error {
 		return err
 	}
 
-	dst := s2.EncodeBest(nil, data)
-
-	if err := os.MkdirAll(path.Join(storage.path, "blocks", block.ID[0:4]), 0755); err != nil {
+	dst, err := seal(storage.key.Encrypt, s2.EncodeBest(nil, data))
+	if err != nil {
 		return err
 	}
 
-	filePath := path.Join(storage.path, "blocks", block.ID[0:4], fmt.Sprintf("%s.dat", block.ID))
-
-	if err := os.WriteFile(filePath, dst, 0640); err != nil {
-		return err
-	}
+	return ioError("write block", block.ID, storage.backend.Put(storage.blockPath(block.ID), dst))
+}
 
-	return nil
+func (storage *Storage) blockPath(id string) string {
+	return path.Join("blocks", id[0:4], fmt.Sprintf("%s.dat", id))
 }
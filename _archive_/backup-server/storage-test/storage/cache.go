@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"sync"
+)
+
+var errBlobOffset = errors.New("blob does not match its offset")
+
+// zeroBlock is compared against chunks in pieces to detect all-zero chunks.
+var zeroBlock = make([]byte, 64<<10)
+
+// ChunkCache keeps recently read chunks in memory, evicting the least
+// recently used ones once the total size exceeds the limit. All-zero chunks
+// are kept by length only. With SetDisk chunks evicted from memory are
+// still found on disk.
+type ChunkCache struct {
+	storage *Storage
+	limit   uint64
+	disk    *diskCache
+
+	mu    sync.Mutex
+	size  uint64
+	order *list.List
+	items map[string]*list.Element
+	loads map[string]*cacheLoad
+}
+
+type cacheItem struct {
+	id     string
+	data   []byte
+	zero   bool
+	length int
+}
+
+// cacheLoad lets concurrent readers of a chunk wait for the first one.
+type cacheLoad struct {
+	done chan struct{}
+	item *cacheItem
+	err  error
+}
+
+func NewChunkCache(storage *Storage, limit uint64) *ChunkCache {
+	return &ChunkCache{
+		storage: storage,
+		limit:   limit,
+		order:   list.New(),
+		items:   make(map[string]*list.Element),
+		loads:   make(map[string]*cacheLoad),
+	}
+}
+
+// SetDisk adds a cache directory holding up to limit bytes of stored
+// chunks. The chunks stay encrypted and are verified on every read, so the
+// directory may be shared between repositories.
+func (cache *ChunkCache) SetDisk(dir string, limit uint64) error {
+	disk, err := openDiskCache(dir, limit)
+	if err != nil {
+		return err
+	}
+
+	cache.disk = disk
+
+	return nil
+}
+
+// GetChunk returns the chunk from the cache or reads it from the storage.
+func (cache *ChunkCache) GetChunk(id string) ([]byte, error) {
+	item, err := cache.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.zero {
+		return make([]byte, item.length), nil
+	}
+
+	return item.data, nil
+}
+
+func (cache *ChunkCache) get(id string) (*cacheItem, error) {
+	cache.mu.Lock()
+
+	if elem, ok := cache.items[id]; ok {
+		cache.order.MoveToFront(elem)
+		cache.mu.Unlock()
+
+		return elem.Value.(*cacheItem), nil
+	}
+
+	if load, ok := cache.loads[id]; ok {
+		cache.mu.Unlock()
+		<-load.done
+
+		return load.item, load.err
+	}
+
+	load := &cacheLoad{done: make(chan struct{})}
+	cache.loads[id] = load
+	cache.mu.Unlock()
+
+	load.item, load.err = cache.load(id)
+
+	cache.mu.Lock()
+	delete(cache.loads, id)
+
+	if load.err == nil {
+		cache.add(load.item)
+	}
+	cache.mu.Unlock()
+
+	close(load.done)
+
+	return load.item, load.err
+}
+
+// load reads the chunk from the disk cache or else from the storage.
+func (cache *ChunkCache) load(id string) (*cacheItem, error) {
+	if cache.disk != nil {
+		if stored, ok := cache.disk.get(id); ok {
+			data, err := cache.storage.decodeChunk(id, stored)
+			if err == nil {
+				return newCacheItem(id, data), nil
+			}
+
+			// written for another repository or damaged
+			cache.disk.remove(id)
+		}
+	}
+
+	stored, err := cache.storage.readChunkData(id)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.storage.readLimit.wait(len(stored))
+
+	data, err := cache.storage.decodeChunk(id, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache.disk != nil {
+		if err := cache.disk.put(id, stored); err != nil {
+			log.Printf("chunk cache disabled: %s", err)
+		}
+	}
+
+	return newCacheItem(id, data), nil
+}
+
+func newCacheItem(id string, data []byte) *cacheItem {
+	if isZero(data) {
+		return &cacheItem{id: id, zero: true, length: len(data)}
+	}
+
+	return &cacheItem{id: id, data: data, length: len(data)}
+}
+
+// add requires cache.mu.
+func (cache *ChunkCache) add(item *cacheItem) {
+	if _, ok := cache.items[item.id]; ok || uint64(len(item.data)) > cache.limit {
+		return
+	}
+
+	cache.items[item.id] = cache.order.PushFront(item)
+	cache.size += uint64(len(item.data))
+
+	for cache.size > cache.limit {
+		elem := cache.order.Back()
+		evicted := elem.Value.(*cacheItem)
+
+		cache.order.Remove(elem)
+		delete(cache.items, evicted.id)
+		cache.size -= uint64(len(evicted.data))
+	}
+}
+
+func isZero(data []byte) bool {
+	for len(data) > 0 {
+		n := len(data)
+		if n > len(zeroBlock) {
+			n = len(zeroBlock)
+		}
+
+		if !bytes.Equal(data[:n], zeroBlock[:n]) {
+			return false
+		}
+
+		data = data[n:]
+	}
+
+	return true
+}
+
+// ReadAt reads len(p) bytes starting at off from the stream made of blobs.
+func (cache *ChunkCache) ReadAt(blobs []Blob, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, io.EOF
+	}
+
+	i := sort.Search(len(blobs), func(i int) bool {
+		return int64(blobs[i].Offset+blobs[i].Length) > off
+	})
+
+	var n int
+
+	for ; i < len(blobs) && n < len(p); i++ {
+		data, err := cache.GetChunk(blobs[i].ID)
+		if err != nil {
+			return n, err
+		}
+
+		start := off + int64(n) - int64(blobs[i].Offset)
+		if start < 0 || start > int64(len(data)) {
+			return n, corruptedError("read chunk", blobs[i].ID, errBlobOffset)
+		}
+
+		n += copy(p[n:], data[start:])
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"container/list"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskCache keeps stored chunks as files named by chunk ID, evicting the
+// least recently used ones once the total size exceeds the limit. The
+// order survives restarts through the file modification times.
+type diskCache struct {
+	dir   string
+	limit uint64
+
+	mu     sync.Mutex
+	size   uint64
+	order  *list.List
+	items  map[string]*list.Element
+	failed bool
+}
+
+type diskItem struct {
+	id   string
+	size uint64
+}
+
+func openDiskCache(dir string, limit uint64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	cache := &diskCache{
+		dir:   dir,
+		limit: limit,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+
+	type cachedFile struct {
+		id      string
+		size    uint64
+		modTime time.Time
+	}
+
+	var files []cachedFile
+
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		// temporary files of an interrupted put
+		if strings.HasPrefix(entry.Name(), ".chunk-") {
+			return os.Remove(name)
+		}
+
+		if _, err := parseChunkID(entry.Name()); err != nil || filepath.Dir(name) != filepath.Dir(cache.path(entry.Name())) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, cachedFile{id: entry.Name(), size: uint64(info.Size()), modTime: info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	for _, file := range files {
+		cache.items[file.id] = cache.order.PushFront(&diskItem{id: file.id, size: file.size})
+		cache.size += file.size
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.evict()
+
+	return cache, nil
+}
+
+func (cache *diskCache) path(id string) string {
+	return filepath.Join(cache.dir, id[0:2], id)
+}
+
+func (cache *diskCache) get(id string) ([]byte, bool) {
+	cache.mu.Lock()
+	elem, ok := cache.items[id]
+	if ok {
+		cache.order.MoveToFront(elem)
+	}
+	cache.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(cache.path(id))
+	if err != nil {
+		cache.remove(id)
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(cache.path(id), now, now)
+
+	return data, true
+}
+
+// put stores the chunk, after the first failure the cache stops writing and
+// only the error of that failure is returned.
+func (cache *diskCache) put(id string, data []byte) error {
+	cache.mu.Lock()
+	_, ok := cache.items[id]
+	skip := ok || cache.failed || uint64(len(data)) > cache.limit
+	cache.mu.Unlock()
+
+	if skip {
+		return nil
+	}
+
+	if err := cache.write(id, data); err != nil {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		cache.failed = true
+
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, ok := cache.items[id]; ok {
+		return nil
+	}
+
+	cache.items[id] = cache.order.PushFront(&diskItem{id: id, size: uint64(len(data))})
+	cache.size += uint64(len(data))
+
+	cache.evict()
+
+	return nil
+}
+
+// write replaces the file atomically, so readers never see a partial chunk.
+func (cache *diskCache) write(id string, data []byte) error {
+	name := cache.path(id)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(name), ".chunk-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), name)
+}
+
+func (cache *diskCache) remove(id string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.items[id]; ok {
+		cache.drop(elem)
+	}
+}
+
+// evict requires cache.mu.
+func (cache *diskCache) evict() {
+	for cache.size > cache.limit {
+		cache.drop(cache.order.Back())
+	}
+}
+
+// drop requires cache.mu.
+func (cache *diskCache) drop(elem *list.Element) {
+	item := elem.Value.(*diskItem)
+
+	cache.order.Remove(elem)
+	delete(cache.items, item.id)
+	cache.size -= item.size
+
+	os.Remove(cache.path(item.id))
+}
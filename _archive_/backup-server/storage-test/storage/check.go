@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+
+	"github.com/rs/xid"
+	"github.com/vitalvas/backup-server/storage-test/backend"
+)
+
+type CheckOptions struct {
+	// ReadData verifies the content of every referenced chunk and the
+	// whole-stream checksums.
+	ReadData bool
+	// ReadDataSubset verifies the content of the given percentage of
+	// randomly selected chunks. Ignored when ReadData is set.
+	ReadDataSubset float64
+}
+
+type CheckError struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+type CheckReport struct {
+	Blocks        int          `json:"blocks"`
+	Snapshots     int          `json:"snapshots"`
+	Trees         int          `json:"trees"`
+	Chunks        int          `json:"chunks"`
+	ChunksRead    int          `json:"chunks_read"`
+	StreamsHashed int          `json:"streams_hashed"`
+	Errors        []CheckError `json:"errors"`
+}
+
+type checker struct {
+	storage *Storage
+	opts    CheckOptions
+	report  *CheckReport
+	// existence of every referenced chunk
+	chunks map[string]bool
+	// content verification result, false for broken chunks
+	verified map[string]bool
+	// chunks already considered for subset verification
+	sampled map[string]bool
+	trees   map[string]bool
+	packs   map[string]bool
+}
+
+func (check *checker) addError(objectType, id string, format string, args ...interface{}) {
+	check.report.Errors = append(check.report.Errors, CheckError{
+		Type:    objectType,
+		ID:      id,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Check verifies that all block manifests and snapshots decode, that every
+// referenced chunk exists and that blob offsets are contiguous. Depending
+// on opts the chunk content is re-hashed and compared with its ID and the
+// whole-stream checksums are recomputed.
+func (storage *Storage) Check(opts CheckOptions) (*CheckReport, error) {
+	check := &checker{
+		storage:  storage,
+		opts:     opts,
+		report:   &CheckReport{Errors: []CheckError{}},
+		chunks:   make(map[string]bool),
+		verified: make(map[string]bool),
+		sampled:  make(map[string]bool),
+		trees:    make(map[string]bool),
+		packs:    make(map[string]bool),
+	}
+
+	if err := check.statPacks(); err != nil {
+		return nil, err
+	}
+
+	blocks, err := storage.ListBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range blocks {
+		check.report.Blocks++
+
+		block, err := storage.GetBlock(id)
+		if err != nil {
+			check.addError("block", id, "%s", err)
+			continue
+		}
+
+		// blocks written before the hash was recorded use the repository hash
+		if len(block.ChunkHash) > 0 && block.ChunkHash != storage.config.ChunkHash {
+			check.addError("block", id, "chunk hash %s, repository uses %s", block.ChunkHash, storage.config.ChunkHash)
+			continue
+		}
+
+		check.checkStream("block", id, block.Blobs, block.Size, block.CheckSum)
+	}
+
+	snapshots, err := storage.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range snapshots {
+		check.report.Snapshots++
+
+		snapshot, err := storage.GetSnapshot(id)
+		if err != nil {
+			check.addError("snapshot", id, "%s", err)
+			continue
+		}
+
+		check.checkTree(snapshot.Tree, "/")
+	}
+
+	check.report.Chunks = len(check.chunks) + len(check.trees)
+
+	return check.report, nil
+}
+
+func (check *checker) checkTree(id string, name string) {
+	if _, ok := check.trees[id]; ok {
+		return
+	}
+
+	check.trees[id] = true
+	check.report.Trees++
+
+	data, err := check.storage.GetChunk(id)
+	if err != nil {
+		check.addError("tree", id, "%s: %s", name, err)
+		return
+	}
+
+	var tree Tree
+
+	if err := json.Unmarshal(data, &tree); err != nil {
+		check.addError("tree", id, "%s: %s", name, err)
+		return
+	}
+
+	for _, node := range tree.Nodes {
+		nodeName := name + node.Name
+
+		switch node.Type {
+		case NodeTypeDir:
+			check.checkTree(node.Subtree, nodeName+"/")
+
+		case NodeTypeFile:
+			check.checkStream("file", nodeName, node.Content, node.Size, node.CheckSum)
+		}
+	}
+}
+
+func (check *checker) checkStream(objectType, id string, blobs []Blob, size uint64, checksum string) {
+	var offset uint64
+
+	for _, blob := range blobs {
+		if uint64(blob.Offset) != offset {
+			check.addError(objectType, id, "blob %s at offset %d, expected %d", blob.ID, blob.Offset, offset)
+		}
+
+		offset = uint64(blob.Offset) + uint64(blob.Length)
+
+		if !check.chunkExists(blob.ID) {
+			check.addError(objectType, id, "chunk %s does not exist", blob.ID)
+		}
+	}
+
+	if offset != size {
+		check.addError(objectType, id, "blobs cover %d bytes, size is %d", offset, size)
+	}
+
+	if check.opts.ReadData {
+		check.hashStream(objectType, id, blobs, checksum)
+		return
+	}
+
+	for _, blob := range blobs {
+		if check.isSelected(blob.ID) {
+			check.verifyChunk(objectType, id, blob)
+		}
+	}
+}
+
+func (check *checker) hashStream(objectType, id string, blobs []Blob, checksum string) {
+	hash := check.storage.hasher.new()
+
+	for _, blob := range blobs {
+		data := check.verifyChunk(objectType, id, blob)
+		if data == nil {
+			return
+		}
+
+		hash.Write(data)
+	}
+
+	check.report.StreamsHashed++
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum {
+		check.addError(objectType, id, "checksum mismatch: %s, expected %s", sum, checksum)
+	}
+}
+
+// verifyChunk reads the chunk, checks its ID and length and returns the
+// content, or nil when the chunk is broken.
+func (check *checker) verifyChunk(objectType, id string, blob Blob) []byte {
+	if ok, seen := check.verified[blob.ID]; seen && !ok {
+		return nil
+	}
+
+	data, err := check.storage.GetChunk(blob.ID)
+	if err == nil && uint(len(data)) != blob.Length {
+		err = fmt.Errorf("chunk %s has %d bytes, expected %d", blob.ID, len(data), blob.Length)
+	}
+
+	check.report.ChunksRead++
+	check.verified[blob.ID] = err == nil
+
+	if err != nil {
+		check.addError(objectType, id, "%s", err)
+		return nil
+	}
+
+	return data
+}
+
+// isSelected decides once per chunk whether it is part of the random subset.
+func (check *checker) isSelected(id string) bool {
+	if check.opts.ReadDataSubset <= 0 || check.sampled[id] {
+		return false
+	}
+
+	check.sampled[id] = true
+
+	return rand.Float64()*100 < check.opts.ReadDataSubset
+}
+
+func (check *checker) chunkExists(id string) bool {
+	if exists, ok := check.chunks[id]; ok {
+		return exists
+	}
+
+	exists := check.lookupChunk(id)
+	check.chunks[id] = exists
+
+	return exists
+}
+
+// statPacks checks the existence of all indexed packs up front, backends
+// supporting batch requests answer it in one round trip.
+func (check *checker) statPacks() error {
+	ids := make(map[string]xid.ID)
+
+	for _, entry := range check.storage.index.all() {
+		if entry.location == locationPack {
+			ids[check.storage.getPackPath(entry.pack)] = entry.pack
+		}
+	}
+
+	names := make([]string, 0, len(ids))
+	for name := range ids {
+		names = append(names, name)
+	}
+
+	exists, err := backend.Exists(check.storage.backend, names)
+	if err != nil {
+		return ioError("check packs", "", err)
+	}
+
+	for name, id := range ids {
+		check.packs[id.String()] = exists[name]
+	}
+
+	return nil
+}
+
+func (check *checker) lookupChunk(id string) bool {
+	chunk, err := parseChunkID(id)
+	if err != nil {
+		return false
+	}
+
+	entry, ok := check.storage.lookup(chunk)
+	if !ok || entry.location == locationLoose {
+		_, err := check.storage.backend.Stat(check.storage.getStoragePath(id))
+		return err == nil
+	}
+
+	pack := entry.pack.String()
+
+	if exists, ok := check.packs[pack]; ok {
+		return exists
+	}
+
+	_, err = check.storage.backend.Stat(check.storage.getPackPath(entry.pack))
+	check.packs[pack] = err == nil
+
+	return err == nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPacks returns the pack files of the repository at path.
+func testPacks(t *testing.T, path string) []string {
+	t.Helper()
+
+	packs, err := filepath.Glob(filepath.Join(path, "packs", "*", "*.pack"))
+	if err != nil || len(packs) == 0 {
+		t.Fatalf("no packs: %v", err)
+	}
+
+	return packs
+}
+
+func checkTestStorage(t *testing.T, store *Storage, opts CheckOptions) *CheckReport {
+	t.Helper()
+
+	report, err := store.Check(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return report
+}
+
+func TestCheckDetectsCorruption(t *testing.T) {
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	block := writeTestBlock(t, store, randomData(1, 300<<10))
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	if report := checkTestStorage(t, store, CheckOptions{ReadData: true}); len(report.Errors) > 0 || report.StreamsHashed != 1 {
+		t.Fatalf("clean repository: %+v", report)
+	}
+
+	// flip a byte inside the first chunk of every pack
+	for _, pack := range testPacks(t, path) {
+		data, err := os.ReadFile(pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data[100] ^= 0xff
+
+		if err := os.WriteFile(pack, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the structure is intact, only reading the data finds the damage
+	if report := checkTestStorage(t, store, CheckOptions{}); len(report.Errors) > 0 {
+		t.Fatalf("check without reading data: %+v", report.Errors)
+	}
+
+	if report := checkTestStorage(t, store, CheckOptions{ReadData: true}); len(report.Errors) == 0 {
+		t.Fatal("corrupted chunk not reported")
+	}
+
+	err := store.RestoreStream(&bytes.Buffer{}, block.Blobs, block.CheckSum, RestoreOptions{})
+	if !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("restore: got %v, want ErrCorrupted", err)
+	}
+}
+
+func TestCheckDetectsMissingPacks(t *testing.T) {
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	writeTestBlock(t, store, randomData(1, 300<<10))
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	for _, pack := range testPacks(t, path) {
+		if err := os.Remove(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if report := checkTestStorage(t, store, CheckOptions{}); len(report.Errors) == 0 {
+		t.Fatal("missing pack not reported")
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// Checkpoint is the partial manifest of a block whose backup has not
+// finished. It lists the blobs written so far and is replaced by the block
+// once the backup completes.
+type Checkpoint struct {
+	Block
+	Updated int64
+}
+
+func (storage *Storage) checkpointPath(id string) string {
+	return path.Join("checkpoints", id[0:4], fmt.Sprintf("%s.dat", id))
+}
+
+func (storage *Storage) writeCheckpoint(checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	dst, err := seal(storage.key.Encrypt, s2.EncodeBest(nil, data))
+	if err != nil {
+		return err
+	}
+
+	return ioError("write checkpoint", checkpoint.ID, storage.backend.Put(storage.checkpointPath(checkpoint.ID), dst))
+}
+
+// ListCheckpoints returns the block IDs of all interrupted backups.
+func (storage *Storage) ListCheckpoints() ([]string, error) {
+	return storage.listObjects("checkpoints")
+}
+
+func (storage *Storage) GetCheckpoint(id string) (checkpoint *Checkpoint, err error) {
+	if err := validateObjectID(id); err != nil {
+		return nil, notFoundError("read checkpoint", id, err)
+	}
+
+	if err := storage.readObject("read checkpoint", id, storage.checkpointPath(id), &checkpoint); err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+func (storage *Storage) DeleteCheckpoint(id string) error {
+	if err := validateObjectID(id); err != nil {
+		return notFoundError("delete checkpoint", id, err)
+	}
+
+	return ioError("delete checkpoint", id, storage.backend.Delete(storage.checkpointPath(id)))
+}
+
+// indexedPrefix returns the leading blobs whose chunks are listed in index
+// files. Chunks still queued for upload, in a pack being uploaded or stored
+// by another writer end the prefix, they are left to the next checkpoint.
+func (storage *Storage) indexedPrefix(blobs []Blob) []Blob {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	for i, blob := range blobs {
+		id, err := parseChunkID(blob.ID)
+		if err != nil || !storage.index.persisted(id) {
+			return blobs[:i]
+		}
+	}
+
+	return blobs
+}
+
+// resumeStream re-reads the input up to the end of the checkpoint and adds
+// every blob whose chunk is indexed and still matches the input. The reader
+// is left at the first byte that has to be written again.
+func (storage *Storage) resumeStream(reader io.ReadSeeker, checkpoint *Checkpoint, st *stream) error {
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	for _, blob := range checkpoint.Blobs {
+		if uint64(blob.Offset) != st.size {
+			break
+		}
+
+		id, err := parseChunkID(blob.ID)
+		if err != nil {
+			break
+		}
+
+		if _, ok := storage.lookup(id); !ok {
+			break
+		}
+
+		data := make([]byte, blob.Length)
+
+		if _, err := io.ReadFull(reader, data); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+
+			return err
+		}
+
+		if storage.hash(data) != blob.ID {
+			break
+		}
+
+		st.checksum.Write(data)
+		st.blobs = append(st.blobs, blob)
+		st.size += uint64(blob.Length)
+	}
+
+	_, err := reader.Seek(int64(st.size), io.SeekStart)
+
+	return err
+}
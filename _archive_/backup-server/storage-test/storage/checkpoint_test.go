@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestIndexedPrefix(t *testing.T) {
+	store := newTestStorage(t, StorageConfig{})
+	defer store.Close()
+
+	var blobs []Blob
+
+	for i := int64(0); i < 3; i++ {
+		_, id, _, err := store.writeChunk(randomData(i, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blobs = append(blobs, Blob{ID: id, Offset: uint(i) * 1024, Length: 1024})
+
+		// the second chunk stays in the open pack
+		if i != 1 {
+			if err := store.flushIndex(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the open pack was finished by the last flush
+	if got := len(store.indexedPrefix(blobs)); got != 3 {
+		t.Fatalf("prefix of %d blobs, want 3", got)
+	}
+
+	_, id, _, err := store.writeChunk(randomData(4, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs = append([]Blob{{ID: id, Length: 1024}}, blobs...)
+
+	if got := len(store.indexedPrefix(blobs)); got != 0 {
+		t.Fatalf("prefix of %d blobs, want 0", got)
+	}
+}
+
+var errTestInterrupted = errors.New("interrupted")
+
+// interruptedReader fails after limit bytes.
+type interruptedReader struct {
+	reader io.Reader
+	limit  int
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if r.limit <= 0 {
+		return 0, errTestInterrupted
+	}
+
+	if len(p) > r.limit {
+		p = p[:r.limit]
+	}
+
+	n, err := r.reader.Read(p)
+	r.limit -= n
+
+	return n, err
+}
+
+func TestParallelCheckpointResume(t *testing.T) {
+	path := t.TempDir()
+
+	// slow uploads let the results run ahead of the stored chunks
+	store := newTestStorage(t, StorageConfig{
+		Path:       path,
+		Create:     true,
+		Workers:    4,
+		Uploaders:  4,
+		WriteLimit: NewThrottle(4<<20, 0),
+	})
+
+	data := randomData(1, 4<<20)
+
+	_, err := store.Writer(&interruptedReader{reader: bytes.NewReader(data), limit: 3 << 20}, WriteOptions{
+		CheckpointInterval: time.Nanosecond,
+	})
+	if !errors.Is(err, errTestInterrupted) {
+		t.Fatalf("got %v, want interrupted backup", err)
+	}
+
+	// like after a crash, the open pack and pending entries are lost
+	crashed := store
+	defer crashed.Close()
+
+	store = newTestStorage(t, StorageConfig{Path: path})
+	defer store.Close()
+
+	ids, err := store.ListCheckpoints()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("got checkpoints %v, %v", ids, err)
+	}
+
+	checkpoint, err := store.GetCheckpoint(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(checkpoint.Blobs) == 0 {
+		t.Fatal("checkpoint without blobs")
+	}
+
+	// every chunk of the checkpoint must be readable after the crash
+	for _, blob := range checkpoint.Blobs {
+		if _, err := store.GetChunk(blob.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := store.Writer(bytes.NewReader(data), WriteOptions{Resume: ids[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := store.GetBlock(stats.BlockID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := restoreTestBlock(t, store, block, RestoreOptions{}); !bytes.Equal(got, data) {
+		t.Fatal("restored data differs")
+	}
+}
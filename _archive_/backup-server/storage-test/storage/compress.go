@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+var errCodecHeader = errors.New("missing codec header")
+
+const (
+	CompressionS2   = "s2"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// codec header byte in front of every compressed chunk
+const (
+	codecNone byte = iota
+	codecS2
+	codecZstd
+)
+
+const (
+	// adaptive compression tries this many leading bytes of a chunk first
+	adaptiveSampleSize = 64 << 10
+	// and stores the chunk uncompressed when the sample does not shrink
+	// below 7/8 of its size
+	adaptiveMinGain = 8
+)
+
+// CompressionParams select how chunks are compressed. Level is only used by
+// zstd, zero selects its default level.
+type CompressionParams struct {
+	Codec    string `json:"codec"`
+	Level    int    `json:"level,omitempty"`
+	Adaptive bool   `json:"adaptive,omitempty"`
+}
+
+func (params CompressionParams) validate() error {
+	switch params.Codec {
+	case CompressionS2, CompressionNone:
+		if params.Level != 0 {
+			return fmt.Errorf("compression level is not supported by %s", params.Codec)
+		}
+
+	case CompressionZstd:
+		if params.Level < 0 || params.Level > 22 {
+			return fmt.Errorf("invalid zstd level: %d", params.Level)
+		}
+
+	default:
+		return fmt.Errorf("unknown compression: %q", params.Codec)
+	}
+
+	return nil
+}
+
+type compressor struct {
+	codec    byte
+	adaptive bool
+
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+}
+
+func newCompressor(conf *Config) (*compressor, error) {
+	comp := &compressor{
+		adaptive: conf.Compression.Adaptive,
+	}
+
+	var err error
+
+	if comp.zstdDecoder, err = zstd.NewReader(nil); err != nil {
+		return nil, err
+	}
+
+	switch conf.Compression.Codec {
+	case CompressionNone:
+		comp.codec = codecNone
+
+	case CompressionZstd:
+		comp.codec = codecZstd
+
+		var opts []zstd.EOption
+
+		if conf.Compression.Level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(conf.Compression.Level)))
+		}
+
+		if comp.zstdEncoder, err = zstd.NewWriter(nil, opts...); err != nil {
+			return nil, err
+		}
+
+	default:
+		comp.codec = codecS2
+	}
+
+	return comp, nil
+}
+
+// compress encodes a chunk and prepends the codec header.
+func (comp *compressor) compress(data []byte) []byte {
+	codec := comp.codec
+
+	if comp.adaptive && codec != codecNone {
+		sample := data
+		if len(sample) > adaptiveSampleSize {
+			sample = sample[:adaptiveSampleSize]
+		}
+
+		if len(s2.Encode(nil, sample))*adaptiveMinGain > len(sample)*(adaptiveMinGain-1) {
+			codec = codecNone
+		}
+	}
+
+	switch codec {
+	case codecS2:
+		dst := make([]byte, 1+s2.MaxEncodedLen(len(data)))
+		dst[0] = codecS2
+
+		return dst[:1+len(s2.Encode(dst[1:], data))]
+
+	case codecZstd:
+		return comp.zstdEncoder.EncodeAll(data, []byte{codecZstd})
+
+	default:
+		return append([]byte{codecNone}, data...)
+	}
+}
+
+func (comp *compressor) decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errCodecHeader
+	}
+
+	switch data[0] {
+	case codecNone:
+		return data[1:], nil
+
+	case codecS2:
+		return s2.Decode(nil, data[1:])
+
+	case codecZstd:
+		return comp.zstdDecoder.DecodeAll(data[1:], nil)
+
+	default:
+		return nil, fmt.Errorf("unknown codec: %d", data[0])
+	}
+}
+
+func (comp *compressor) close() {
+	comp.zstdDecoder.Close()
+
+	if comp.zstdEncoder != nil {
+		comp.zstdEncoder.Close()
+	}
+}
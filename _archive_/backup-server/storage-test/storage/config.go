@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/restic/chunker"
+	"github.com/vitalvas/backup-server/storage-test/backend"
+	"github.com/zeebo/blake3"
+)
+
+const (
+	configFileName = "config"
+	configVersion  = 1
+
+	defaultChunkerMinSize = 256 * (1 << 10) // 256 KB
+	defaultChunkerAvgSize = 1 << 20         // 1 MB
+	defaultChunkerMaxSize = chunker.MaxSize
+)
+
+// ChunkerParams select the content defined chunk boundaries of a
+// repository. AvgSize must be a power of two.
+type ChunkerParams struct {
+	Polynomial chunker.Pol `json:"polynomial"`
+	MinSize    uint        `json:"min_size"`
+	AvgSize    uint        `json:"avg_size"`
+	MaxSize    uint        `json:"max_size"`
+}
+
+// Config is created together with the repository key and never changes
+// afterwards, all clients must chunk with the same parameters to dedup.
+// Version is the format version of the repository, checked on open.
+type Config struct {
+	Version     int               `json:"version"`
+	ChunkHash   string            `json:"chunk_hash"`
+	Chunker     ChunkerParams     `json:"chunker"`
+	Compression CompressionParams `json:"compression"`
+	Created     time.Time         `json:"created"`
+}
+
+// derivedPolynomial is a fixed polynomial for discard mode, which stores no
+// config.
+func derivedPolynomial() (chunker.Pol, error) {
+	chunkerPolHash := blake3.NewDeriveKey("backup-server/storage-test")
+	return chunker.DerivePolynomial(chunkerPolHash.Digest())
+}
+
+// newConfig fills unset parameters with defaults, a missing polynomial is
+// chosen at random.
+func newConfig(params ChunkerParams, compression CompressionParams, chunkHash string) (*Config, error) {
+	if len(chunkHash) == 0 {
+		chunkHash = ChunkHashHighwayHash
+	}
+
+	if len(compression.Codec) == 0 {
+		compression.Codec = CompressionS2
+	}
+
+	if params.MinSize == 0 {
+		params.MinSize = defaultChunkerMinSize
+	}
+
+	if params.AvgSize == 0 {
+		params.AvgSize = defaultChunkerAvgSize
+	}
+
+	if params.MaxSize == 0 {
+		params.MaxSize = defaultChunkerMaxSize
+	}
+
+	if params.Polynomial == 0 {
+		pol, err := chunker.RandomPolynomial()
+		if err != nil {
+			return nil, err
+		}
+
+		params.Polynomial = pol
+	}
+
+	conf := &Config{
+		Version:     configVersion,
+		ChunkHash:   chunkHash,
+		Chunker:     params,
+		Compression: compression,
+		Created:     time.Now().UTC(),
+	}
+
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+func (conf *Config) validate() error {
+	if err := conf.Compression.validate(); err != nil {
+		return err
+	}
+
+	if err := validateChunkHash(conf.ChunkHash); err != nil {
+		return err
+	}
+
+	params := conf.Chunker
+
+	if !params.Polynomial.Irreducible() {
+		return errors.New("chunker polynomial is not irreducible")
+	}
+
+	if params.AvgSize&(params.AvgSize-1) != 0 {
+		return fmt.Errorf("chunker average size %d is not a power of two", params.AvgSize)
+	}
+
+	if params.MinSize >= params.AvgSize || params.AvgSize >= params.MaxSize {
+		return fmt.Errorf("chunker sizes must be min < avg < max, got %d/%d/%d", params.MinSize, params.AvgSize, params.MaxSize)
+	}
+
+	return nil
+}
+
+func (storage *Storage) newChunker(reader io.Reader) *chunker.Chunker {
+	params := storage.config.Chunker
+
+	fileChunker := chunker.NewWithBoundaries(reader, params.Polynomial, params.MinSize, params.MaxSize)
+	fileChunker.SetAverageBits(bits.Len(params.AvgSize) - 1)
+
+	return fileChunker
+}
+
+func (storage *Storage) writeConfig() error {
+	data, err := json.Marshal(storage.config)
+	if err != nil {
+		return err
+	}
+
+	dst, err := seal(storage.key.Encrypt, s2.EncodeBest(nil, data))
+	if err != nil {
+		return err
+	}
+
+	return ioError("write config", "", storage.backend.Put(configFileName, dst))
+}
+
+func (storage *Storage) loadConfig() error {
+	if _, err := storage.backend.Stat(configFileName); err != nil {
+		if !errors.Is(err, backend.ErrNotExist) {
+			return ioError("read config", "", err)
+		}
+
+		// the config is written together with the key
+		return corruptedError("read config", "", err)
+	}
+
+	var conf Config
+
+	if err := storage.readObject("read config", "", configFileName, &conf); err != nil {
+		return err
+	}
+
+	if conf.Version > configVersion {
+		return corruptedError("read config", "", fmt.Errorf("repository format version %d is newer than the supported version %d", conf.Version, configVersion))
+	}
+
+	if conf.Version < 1 {
+		return corruptedError("read config", "", fmt.Errorf("invalid repository format version: %d", conf.Version))
+	}
+
+	if err := conf.validate(); err != nil {
+		return corruptedError("read config", "", err)
+	}
+
+	storage.config = &conf
+
+	return nil
+}
+
+// Config returns the repository config.
+func (storage *Storage) Config() Config {
+	return *storage.config
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/vitalvas/backup-server/storage-test/backend"
+)
+
+var errIncompatibleRepository = errors.New("destination does not share the chunk ID key and hash of the source repository")
+
+type CopyOptions struct {
+	// BlockIDs selects the blocks to copy, all blocks when empty.
+	BlockIDs []string
+	// Since and Until limit the blocks by timestamp, zero means unbounded.
+	Since int64
+	Until int64
+	// Snapshots copies the snapshots within Since and Until as well.
+	Snapshots bool
+}
+
+type CopyReport struct {
+	Blocks           int
+	SkippedBlocks    int
+	Snapshots        int
+	SkippedSnapshots int
+	Chunks           int
+	CopiedChunks     int
+	CopiedBytes      uint64
+}
+
+// Copy transfers blocks into dst, which must share the chunk ID key and hash, see
+// StorageConfig.Source. Only chunks missing in dst are read and uploaded.
+// Every block is written after its chunks are indexed in dst and blocks
+// already present are skipped, so an interrupted copy resumes where it
+// stopped.
+func (storage *Storage) Copy(dst *Storage, opts CopyOptions) (*CopyReport, error) {
+	if !bytes.Equal(storage.key.ChunkID, dst.key.ChunkID) || storage.config.ChunkHash != dst.config.ChunkHash {
+		return nil, errIncompatibleRepository
+	}
+
+	ids := opts.BlockIDs
+
+	if len(ids) == 0 {
+		var err error
+
+		if ids, err = storage.ListBlocks(); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &CopyReport{}
+
+	for _, id := range ids {
+		block, err := storage.GetBlock(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if (opts.Since > 0 && block.Timestamp < opts.Since) || (opts.Until > 0 && block.Timestamp > opts.Until) {
+			continue
+		}
+
+		_, err = dst.backend.Stat(dst.blockPath(id))
+		if err == nil {
+			report.SkippedBlocks++
+			continue
+		}
+
+		if !errors.Is(err, backend.ErrNotExist) {
+			return nil, ioError("copy block", id, err)
+		}
+
+		if err := storage.copyBlock(dst, block, report); err != nil {
+			return nil, err
+		}
+
+		report.Blocks++
+	}
+
+	if opts.Snapshots {
+		if err := storage.copySnapshots(dst, opts, report); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+func (storage *Storage) copySnapshots(dst *Storage, opts CopyOptions, report *CopyReport) error {
+	ids, err := storage.ListSnapshots()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		snapshot, err := storage.GetSnapshot(id)
+		if err != nil {
+			return err
+		}
+
+		if (opts.Since > 0 && snapshot.Timestamp < opts.Since) || (opts.Until > 0 && snapshot.Timestamp > opts.Until) {
+			continue
+		}
+
+		_, err = dst.backend.Stat(dst.snapshotPath(id))
+		if err == nil {
+			report.SkippedSnapshots++
+			continue
+		}
+
+		if !errors.Is(err, backend.ErrNotExist) {
+			return ioError("copy snapshot", id, err)
+		}
+
+		if err := storage.copyTree(dst, snapshot.Tree, report); err != nil {
+			return err
+		}
+
+		if err := dst.flushIndex(); err != nil {
+			return err
+		}
+
+		if err := dst.writeSnapshot(snapshot); err != nil {
+			return err
+		}
+
+		report.Snapshots++
+	}
+
+	return nil
+}
+
+// copyTree copies the tree chunk, its file content and its subtrees.
+func (storage *Storage) copyTree(dst *Storage, id string, report *CopyReport) error {
+	tree, err := storage.GetTree(id)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range tree.Nodes {
+		switch node.Type {
+		case NodeTypeDir:
+			if err := storage.copyTree(dst, node.Subtree, report); err != nil {
+				return err
+			}
+
+		case NodeTypeFile:
+			for _, blob := range node.Content {
+				if err := storage.copyChunk(dst, blob.ID, int(blob.Length), report); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// the tree goes last, a copied tree implies copied children
+	return storage.copyChunk(dst, id, -1, report)
+}
+
+func (storage *Storage) copyBlock(dst *Storage, block *Block, report *CopyReport) error {
+	for _, blob := range block.Blobs {
+		if err := storage.copyChunk(dst, blob.ID, int(blob.Length), report); err != nil {
+			return err
+		}
+	}
+
+	// the block may only appear once all its chunks are indexed
+	if err := dst.flushIndex(); err != nil {
+		return err
+	}
+
+	return dst.writeBlock(block)
+}
+
+// copyChunk stores the chunk in dst unless it is known there, a negative
+// length skips the length check.
+func (storage *Storage) copyChunk(dst *Storage, blobID string, length int, report *CopyReport) error {
+	report.Chunks++
+
+	id, err := parseChunkID(blobID)
+	if err != nil {
+		return corruptedError("copy chunk", blobID, err)
+	}
+
+	if !dst.claimChunk(id) {
+		return nil
+	}
+
+	data, err := storage.GetChunk(blobID)
+	if err != nil {
+		return err
+	}
+
+	if length >= 0 && len(data) != length {
+		return corruptedError("copy chunk", blobID, fmt.Errorf("chunk has %d bytes, expected %d", len(data), length))
+	}
+
+	sealed, err := dst.encodeChunk(data)
+	if err != nil {
+		return err
+	}
+
+	if err := dst.storeChunk(id, sealed); err != nil {
+		return err
+	}
+
+	report.CopiedChunks++
+	report.CopiedBytes += uint64(len(data))
+
+	return nil
+}
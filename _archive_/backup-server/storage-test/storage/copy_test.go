@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	source := newTestStorage(t, StorageConfig{})
+	defer source.Close()
+
+	first := randomData(1, 200<<10)
+	second := append(append([]byte{}, first...), randomData(2, 100<<10)...)
+
+	blocks := []*Block{
+		writeTestBlock(t, source, first),
+		writeTestBlock(t, source, second),
+	}
+
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string][]byte{"file": first})
+
+	snapshot := backupTestPath(t, source, root)
+
+	path := t.TempDir()
+	dst := newTestStorage(t, StorageConfig{Path: path, Create: true, Source: source})
+
+	report, err := source.Copy(dst, CopyOptions{Snapshots: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Blocks != 2 || report.Snapshots != 1 || report.CopiedChunks >= report.Chunks {
+		t.Fatalf("got report %+v", report)
+	}
+
+	dst = reopenTestStorage(t, dst, path)
+	defer dst.Close()
+
+	for i, data := range [][]byte{first, second} {
+		block, err := dst.GetBlock(blocks[i].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := restoreTestBlock(t, dst, block, RestoreOptions{}); !bytes.Equal(got, data) {
+			t.Fatalf("block %s: restored data differs", block.ID)
+		}
+	}
+
+	copied, err := dst.GetSnapshot(snapshot.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := dst.FindNode(copied, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := restoreTestBlock(t, dst, &Block{Blobs: node.Content, CheckSum: node.CheckSum}, RestoreOptions{}); !bytes.Equal(got, first) {
+		t.Fatal("snapshot file differs")
+	}
+
+	// a second copy finds everything in place
+	report, err = source.Copy(dst, CopyOptions{Snapshots: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Blocks != 0 || report.SkippedBlocks != 2 || report.SkippedSnapshots != 1 {
+		t.Fatalf("got report %+v", report)
+	}
+}
+
+func TestCopyIncompatible(t *testing.T) {
+	source := newTestStorage(t, StorageConfig{})
+	defer source.Close()
+
+	dst := newTestStorage(t, StorageConfig{})
+	defer dst.Close()
+
+	if _, err := source.Copy(dst, CopyOptions{}); !errors.Is(err, errIncompatibleRepository) {
+		t.Fatalf("got %v, want errIncompatibleRepository", err)
+	}
+}
@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"path"
+	"sort"
+)
+
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+type ByteRange struct {
+	Offset uint64 `json:"offset"`
+	Length uint64 `json:"length"`
+}
+
+// BlockDiff compares the chunk sequences of two blocks. The chunk counts and
+// their bytes count every distinct chunk once, even if a block repeats it.
+// Changed lists the ranges of the new block made of chunks the old block does
+// not contain.
+type BlockDiff struct {
+	OldSize       uint64      `json:"old_size"`
+	NewSize       uint64      `json:"new_size"`
+	SharedChunks  int         `json:"shared_chunks"`
+	SharedBytes   uint64      `json:"shared_bytes"`
+	NewChunks     int         `json:"new_chunks"`
+	NewBytes      uint64      `json:"new_bytes"`
+	RemovedChunks int         `json:"removed_chunks"`
+	RemovedBytes  uint64      `json:"removed_bytes"`
+	Changed       []ByteRange `json:"changed"`
+}
+
+type FileChange struct {
+	Path    string `json:"path"`
+	Change  string `json:"change"`
+	OldSize uint64 `json:"old_size"`
+	NewSize uint64 `json:"new_size"`
+}
+
+// DiffBlocks compares block oldID with block newID.
+func (storage *Storage) DiffBlocks(oldID, newID string) (*BlockDiff, error) {
+	oldBlock, err := storage.GetBlock(oldID)
+	if err != nil {
+		return nil, err
+	}
+
+	newBlock, err := storage.GetBlock(newID)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffBlobs(oldBlock.Blobs, newBlock.Blobs), nil
+}
+
+func diffBlobs(oldBlobs, newBlobs []Blob) *BlockDiff {
+	diff := &BlockDiff{Changed: []ByteRange{}}
+
+	oldChunks := make(map[string]struct{}, len(oldBlobs))
+	for _, blob := range oldBlobs {
+		oldChunks[blob.ID] = struct{}{}
+		diff.OldSize += uint64(blob.Length)
+	}
+
+	newChunks := make(map[string]struct{}, len(newBlobs))
+
+	for _, blob := range newBlobs {
+		diff.NewSize += uint64(blob.Length)
+
+		_, shared := oldChunks[blob.ID]
+
+		if _, seen := newChunks[blob.ID]; !seen {
+			newChunks[blob.ID] = struct{}{}
+
+			if shared {
+				diff.SharedChunks++
+				diff.SharedBytes += uint64(blob.Length)
+			} else {
+				diff.NewChunks++
+				diff.NewBytes += uint64(blob.Length)
+			}
+		}
+
+		if shared {
+			continue
+		}
+
+		// merge adjacent new chunks into one range
+		if last := len(diff.Changed) - 1; last >= 0 && diff.Changed[last].Offset+diff.Changed[last].Length == uint64(blob.Offset) {
+			diff.Changed[last].Length += uint64(blob.Length)
+		} else {
+			diff.Changed = append(diff.Changed, ByteRange{
+				Offset: uint64(blob.Offset),
+				Length: uint64(blob.Length),
+			})
+		}
+	}
+
+	removed := make(map[string]struct{})
+
+	for _, blob := range oldBlobs {
+		if _, ok := newChunks[blob.ID]; ok {
+			continue
+		}
+
+		if _, ok := removed[blob.ID]; ok {
+			continue
+		}
+
+		removed[blob.ID] = struct{}{}
+
+		diff.RemovedChunks++
+		diff.RemovedBytes += uint64(blob.Length)
+	}
+
+	return diff
+}
+
+// DiffSnapshots lists the files added, removed or modified between
+// snapshot oldID and snapshot newID. Unchanged directories share their
+// tree ID and are skipped without reading them.
+func (storage *Storage) DiffSnapshots(oldID, newID string) ([]FileChange, error) {
+	oldSnapshot, err := storage.GetSnapshot(oldID)
+	if err != nil {
+		return nil, err
+	}
+
+	newSnapshot, err := storage.GetSnapshot(newID)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []FileChange{}
+
+	if err := storage.diffTrees("/", oldSnapshot.Tree, newSnapshot.Tree, &changes); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+func (storage *Storage) treeNodes(id string) (map[string]*Node, error) {
+	nodes := make(map[string]*Node)
+
+	if len(id) == 0 {
+		return nodes, nil
+	}
+
+	tree, err := storage.GetTree(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tree.Nodes {
+		nodes[tree.Nodes[i].Name] = &tree.Nodes[i]
+	}
+
+	return nodes, nil
+}
+
+func (storage *Storage) diffTrees(prefix, oldTree, newTree string, changes *[]FileChange) error {
+	if oldTree == newTree {
+		return nil
+	}
+
+	oldNodes, err := storage.treeNodes(oldTree)
+	if err != nil {
+		return err
+	}
+
+	newNodes, err := storage.treeNodes(newTree)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(oldNodes)+len(newNodes))
+
+	for name := range oldNodes {
+		names = append(names, name)
+	}
+
+	for name := range newNodes {
+		if _, ok := oldNodes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		oldNode, newNode := oldNodes[name], newNodes[name]
+		nodePath := path.Join(prefix, name)
+
+		switch {
+		case newNode == nil:
+			if err := storage.diffNode(nodePath, ChangeRemoved, oldNode, changes); err != nil {
+				return err
+			}
+
+		case oldNode == nil:
+			if err := storage.diffNode(nodePath, ChangeAdded, newNode, changes); err != nil {
+				return err
+			}
+
+		case oldNode.Type != newNode.Type:
+			if err := storage.diffNode(nodePath, ChangeRemoved, oldNode, changes); err != nil {
+				return err
+			}
+
+			if err := storage.diffNode(nodePath, ChangeAdded, newNode, changes); err != nil {
+				return err
+			}
+
+		case oldNode.Type == NodeTypeDir:
+			if oldNode.Mode != newNode.Mode {
+				*changes = append(*changes, FileChange{Path: nodePath + "/", Change: ChangeModified})
+			}
+
+			if err := storage.diffTrees(nodePath, oldNode.Subtree, newNode.Subtree, changes); err != nil {
+				return err
+			}
+
+		case oldNode.CheckSum != newNode.CheckSum || oldNode.LinkTarget != newNode.LinkTarget || oldNode.Mode != newNode.Mode:
+			*changes = append(*changes, FileChange{
+				Path:    nodePath,
+				Change:  ChangeModified,
+				OldSize: oldNode.Size,
+				NewSize: newNode.Size,
+			})
+		}
+	}
+
+	return nil
+}
+
+// diffNode records a node and, for directories, everything below it as
+// added or removed.
+func (storage *Storage) diffNode(nodePath, change string, node *Node, changes *[]FileChange) error {
+	add := func(name string, node *Node) {
+		fileChange := FileChange{Path: name, Change: change}
+
+		if node.Type == NodeTypeDir {
+			fileChange.Path += "/"
+		}
+
+		if change == ChangeAdded {
+			fileChange.NewSize = node.Size
+		} else {
+			fileChange.OldSize = node.Size
+		}
+
+		*changes = append(*changes, fileChange)
+	}
+
+	add(nodePath, node)
+
+	if node.Type != NodeTypeDir {
+		return nil
+	}
+
+	return storage.WalkTree(node.Subtree, nodePath, func(name string, node *Node) error {
+		add(name, node)
+		return nil
+	})
+}
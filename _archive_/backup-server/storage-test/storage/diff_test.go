@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testBlobs lays out one 10 byte blob per character of ids.
+func testBlobs(ids string) []Blob {
+	blobs := make([]Blob, 0, len(ids))
+
+	for i, id := range ids {
+		blobs = append(blobs, Blob{ID: string(id), Offset: uint(i * 10), Length: 10})
+	}
+
+	return blobs
+}
+
+func TestDiffBlobs(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     BlockDiff
+	}{
+		{
+			name: "unchanged",
+			old:  "abc", new: "abc",
+			want: BlockDiff{OldSize: 30, NewSize: 30, SharedChunks: 3, SharedBytes: 30, Changed: []ByteRange{}},
+		},
+		{
+			name: "appended",
+			old:  "ab", new: "abcd",
+			want: BlockDiff{
+				OldSize: 20, NewSize: 40, SharedChunks: 2, SharedBytes: 20, NewChunks: 2, NewBytes: 20,
+				Changed: []ByteRange{{Offset: 20, Length: 20}},
+			},
+		},
+		{
+			name: "replaced",
+			old:  "abc", new: "axc",
+			want: BlockDiff{
+				OldSize: 30, NewSize: 30, SharedChunks: 2, SharedBytes: 20, NewChunks: 1, NewBytes: 10,
+				RemovedChunks: 1, RemovedBytes: 10, Changed: []ByteRange{{Offset: 10, Length: 10}},
+			},
+		},
+		{
+			name: "repeated chunks count once",
+			old:  "aabbb", new: "aaxxcx",
+			want: BlockDiff{
+				OldSize: 50, NewSize: 60, SharedChunks: 1, SharedBytes: 10, NewChunks: 2, NewBytes: 20,
+				RemovedChunks: 1, RemovedBytes: 10, Changed: []ByteRange{{Offset: 20, Length: 40}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := diffBlobs(testBlobs(test.old), testBlobs(test.new))
+
+			if !reflect.DeepEqual(*got, test.want) {
+				t.Fatalf("got %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
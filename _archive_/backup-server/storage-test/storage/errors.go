@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rs/xid"
+	"github.com/vitalvas/backup-server/storage-test/backend"
+)
+
+type ErrorKind int
+
+const (
+	KindIO ErrorKind = iota + 1
+	KindNotFound
+	KindCorrupted
+	KindLocked
+)
+
+var (
+	ErrIO        = errors.New("i/o error")
+	ErrNotFound  = errors.New("not found")
+	ErrCorrupted = errors.New("corrupted data")
+	ErrLocked    = errors.New("repository locked")
+)
+
+var (
+	ErrNotInitialized = errors.New("repository is not initialized, run init first")
+	ErrExists         = errors.New("repository already exists")
+	ErrNotEmpty       = errors.New("location is not empty, refusing to create a repository")
+	// ErrPlainRepository is returned for the unencrypted layout of the
+	// first versions, see Storage.ImportPlain.
+	ErrPlainRepository = errors.New("repository has the unencrypted legacy layout, import it with migrate --to")
+)
+
+// Error describes a failed repository operation. Use errors.Is with
+// ErrIO, ErrNotFound, ErrCorrupted or ErrLocked to check the kind.
+type Error struct {
+	Kind ErrorKind
+	Op   string
+	ID   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if len(e.ID) > 0 {
+		return fmt.Sprintf("%s %s: %s", e.Op, e.ID, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Op, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrIO:
+		return e.Kind == KindIO
+	case ErrNotFound:
+		return e.Kind == KindNotFound
+	case ErrCorrupted:
+		return e.Kind == KindCorrupted
+	case ErrLocked:
+		return e.Kind == KindLocked
+	}
+
+	return false
+}
+
+// ioError wraps a backend error, missing objects become not-found errors.
+func ioError(op, id string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var storageErr *Error
+	if errors.As(err, &storageErr) {
+		return err
+	}
+
+	kind := KindIO
+	if errors.Is(err, backend.ErrNotExist) {
+		kind = KindNotFound
+	}
+
+	return &Error{Kind: kind, Op: op, ID: id, Err: err}
+}
+
+func corruptedError(op, id string, err error) error {
+	return &Error{Kind: KindCorrupted, Op: op, ID: id, Err: err}
+}
+
+func notFoundError(op, id string, err error) error {
+	return &Error{Kind: KindNotFound, Op: op, ID: id, Err: err}
+}
+
+// validateObjectID checks block and snapshot IDs before they are used to
+// build object names.
+func validateObjectID(id string) error {
+	if _, err := xid.FromString(id); err != nil {
+		return fmt.Errorf("invalid id %q", id)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"github.com/minio/highwayhash"
+	"github.com/zeebo/blake3"
+)
+
+// Chunk ID hashes, all of them are keyed with the chunk ID key of the
+// repository so IDs do not reveal the content.
+const (
+	// ChunkHashHighwayHash is the keyed HighwayHash-256 of the chunk data.
+	ChunkHashHighwayHash = "highwayhash-256"
+	// ChunkHashBLAKE3 is the keyed BLAKE3 of the chunk data.
+	ChunkHashBLAKE3 = "blake3-256"
+	// ChunkHashSHA256 is HMAC-SHA-256 of the chunk data, for environments
+	// limited to approved algorithms.
+	ChunkHashSHA256 = "hmac-sha256"
+)
+
+func validateChunkHash(name string) error {
+	switch name {
+	case ChunkHashHighwayHash, ChunkHashBLAKE3, ChunkHashSHA256:
+		return nil
+	}
+
+	return fmt.Errorf("unknown chunk hash: %q", name)
+}
+
+// chunkHasher computes chunk IDs and stream checksums with the hash of the
+// repository.
+type chunkHasher struct {
+	algorithm string
+	key       []byte
+}
+
+func newChunkHasher(algorithm string, key []byte) (*chunkHasher, error) {
+	if err := validateChunkHash(algorithm); err != nil {
+		return nil, err
+	}
+
+	hasher := &chunkHasher{
+		algorithm: algorithm,
+		key:       key,
+	}
+
+	// the key length is only checked by the constructors
+	if _, err := hasher.create(); err != nil {
+		return nil, err
+	}
+
+	return hasher, nil
+}
+
+func (hasher *chunkHasher) create() (hash.Hash, error) {
+	switch hasher.algorithm {
+	case ChunkHashBLAKE3:
+		return blake3.NewKeyed(hasher.key)
+
+	case ChunkHashSHA256:
+		return hmac.New(sha256.New, hasher.key), nil
+
+	default:
+		return highwayhash.New(hasher.key)
+	}
+}
+
+// new returns a hash for stream checksums.
+func (hasher *chunkHasher) new() hash.Hash {
+	// the key was checked by newChunkHasher
+	hash, _ := hasher.create()
+	return hash
+}
+
+func (hasher *chunkHasher) sum(data []byte) chunkID {
+	if hasher.algorithm == ChunkHashHighwayHash {
+		return chunkID(highwayhash.Sum(data, hasher.key))
+	}
+
+	var id chunkID
+
+	hash := hasher.new()
+	hash.Write(data)
+	copy(id[:], hash.Sum(nil))
+
+	return id
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/minio/highwayhash"
+	"github.com/zeebo/blake3"
+)
+
+func TestChunkHashes(t *testing.T) {
+	data := randomData(1, 300<<10)
+
+	ids := make(map[string]string)
+
+	for _, algorithm := range []string{ChunkHashHighwayHash, ChunkHashBLAKE3, ChunkHashSHA256} {
+		t.Run(algorithm, func(t *testing.T) {
+			path := t.TempDir()
+			store := newTestStorage(t, StorageConfig{Path: path, Create: true, ChunkHash: algorithm})
+
+			block := writeTestBlock(t, store, data)
+
+			store = reopenTestStorage(t, store, path)
+			defer store.Close()
+
+			if hash := store.Config().ChunkHash; hash != algorithm {
+				t.Fatalf("repository uses %s", hash)
+			}
+
+			if block.ChunkHash != algorithm {
+				t.Fatalf("block records %s", block.ChunkHash)
+			}
+
+			// the chunk ID is the keyed hash of the content
+			chunk := data[:block.Blobs[0].Length]
+
+			if want := testKeyedHash(t, algorithm, store.key.ChunkID, chunk); block.Blobs[0].ID != want {
+				t.Fatalf("chunk id %s, want %s", block.Blobs[0].ID, want)
+			}
+
+			if got := restoreTestBlock(t, store, block, RestoreOptions{}); string(got) != string(data) {
+				t.Fatal("restored data differs")
+			}
+
+			if report := checkTestStorage(t, store, CheckOptions{ReadData: true}); len(report.Errors) > 0 {
+				t.Fatalf("check: %+v", report.Errors)
+			}
+
+			ids[algorithm] = block.Blobs[0].ID
+		})
+	}
+
+	if ids[ChunkHashHighwayHash] == ids[ChunkHashBLAKE3] || ids[ChunkHashBLAKE3] == ids[ChunkHashSHA256] {
+		t.Fatal("hashes give the same chunk ids")
+	}
+}
+
+func testKeyedHash(t *testing.T, algorithm string, key, data []byte) string {
+	t.Helper()
+
+	switch algorithm {
+	case ChunkHashBLAKE3:
+		hash, err := blake3.NewKeyed(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash.Write(data)
+
+		return hex.EncodeToString(hash.Sum(nil))
+
+	case ChunkHashSHA256:
+		hash := hmac.New(sha256.New, key)
+		hash.Write(data)
+
+		return hex.EncodeToString(hash.Sum(nil))
+
+	default:
+		sum := highwayhash.Sum(data, key)
+
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+func TestUnknownChunkHash(t *testing.T) {
+	if _, err := New(StorageConfig{Path: t.TempDir(), Password: testPassword, Create: true, ChunkHash: "md5"}); err == nil {
+		t.Fatal("created a repository with an unknown hash")
+	}
+}
+
+func TestVerifyOnRead(t *testing.T) {
+	store := newTestStorage(t, StorageConfig{})
+	defer store.Close()
+
+	block := writeTestBlock(t, store, randomData(1, 100<<10))
+
+	id := block.Blobs[0].ID
+
+	data, err := store.GetChunk(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a valid chunk stored under another ID
+	sealed, err := store.encodeChunk(append(data, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.decodeChunk(id, sealed); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("got %v, want ErrCorrupted", err)
+	}
+
+	if _, err := store.decodeChunk(id, sealed[:len(sealed)-1]); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("truncated chunk: got %v, want ErrCorrupted", err)
+	}
+}
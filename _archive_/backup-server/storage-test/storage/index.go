@@ -0,0 +1,472 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/rs/xid"
+)
+
+const (
+	indexMagic   = "BSIX"
+	indexVersion = 1
+)
+
+// flush pending index entries after this many new chunks, a variable so
+// tests can lower it
+var indexFlushEntries = 100000
+
+const (
+	locationLoose uint8 = iota
+	locationPack
+)
+
+var errIndexFormat = errors.New("invalid index file format")
+
+type chunkID [32]byte
+
+type indexEntry struct {
+	id       chunkID
+	size     uint32
+	location uint8
+	pack     xid.ID
+	offset   uint32
+}
+
+// index keeps the sorted set of known chunks loaded from the index files
+// plus the entries added during the current run.
+type index struct {
+	entries []indexEntry
+	pending map[chunkID]indexEntry
+	files   []string
+}
+
+func newIndex() *index {
+	return &index{
+		pending: make(map[chunkID]indexEntry),
+	}
+}
+
+func parseChunkID(id string) (chunkID, error) {
+	var out chunkID
+
+	data, err := hex.DecodeString(id)
+	if err != nil || len(data) != len(out) {
+		return out, fmt.Errorf("invalid chunk id: %q", id)
+	}
+
+	copy(out[:], data)
+
+	return out, nil
+}
+
+func (id chunkID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (idx *index) search(id chunkID) int {
+	return sort.Search(len(idx.entries), func(i int) bool {
+		return bytes.Compare(idx.entries[i].id[:], id[:]) >= 0
+	})
+}
+
+func (idx *index) get(id chunkID) (indexEntry, bool) {
+	if entry, ok := idx.pending[id]; ok {
+		return entry, true
+	}
+
+	if i := idx.search(id); i < len(idx.entries) && idx.entries[i].id == id {
+		return idx.entries[i], true
+	}
+
+	return indexEntry{}, false
+}
+
+// persisted reports whether the chunk is listed in an index file, pending
+// entries are not.
+func (idx *index) persisted(id chunkID) bool {
+	i := idx.search(id)
+	return i < len(idx.entries) && idx.entries[i].id == id
+}
+
+func (idx *index) has(id chunkID) bool {
+	_, ok := idx.get(id)
+	return ok
+}
+
+func (idx *index) add(entry indexEntry) {
+	idx.pending[entry.id] = entry
+}
+
+// merge adds entries to the sorted set, an entry replaces a known one with
+// the same ID. Later entries win, so entries of several files are merged in
+// one call in load order.
+func (idx *index) merge(entries []indexEntry) {
+	idx.entries = mergeEntries(idx.entries, sortEntries(entries))
+}
+
+// sortEntries sorts by ID and keeps the last of duplicate entries.
+func sortEntries(entries []indexEntry) []indexEntry {
+	sorted := append([]indexEntry(nil), entries...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].id[:], sorted[j].id[:]) < 0
+	})
+
+	out := sorted[:0]
+	for _, entry := range sorted {
+		if len(out) > 0 && out[len(out)-1].id == entry.id {
+			out[len(out)-1] = entry
+			continue
+		}
+
+		out = append(out, entry)
+	}
+
+	return out
+}
+
+// mergeEntries merges two sorted runs, entries of b replace those of a.
+func mergeEntries(a, b []indexEntry) []indexEntry {
+	if len(a) == 0 {
+		return b
+	}
+
+	out := make([]indexEntry, 0, len(a)+len(b))
+
+	for len(a) > 0 && len(b) > 0 {
+		switch bytes.Compare(a[0].id[:], b[0].id[:]) {
+		case -1:
+			out = append(out, a[0])
+			a = a[1:]
+
+		case 1:
+			out = append(out, b[0])
+			b = b[1:]
+
+		default:
+			out = append(out, b[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+
+	out = append(out, a...)
+
+	return append(out, b...)
+}
+
+func (idx *index) pendingEntries() []indexEntry {
+	entries := make([]indexEntry, 0, len(idx.pending))
+	for _, entry := range idx.pending {
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// commit moves already persisted pending entries into the sorted set.
+func (idx *index) commit(entries []indexEntry) {
+	idx.merge(entries)
+
+	for _, entry := range entries {
+		if idx.pending[entry.id] == entry {
+			delete(idx.pending, entry.id)
+		}
+	}
+}
+
+// all returns every known entry, including the pending ones.
+func (idx *index) all() []indexEntry {
+	entries := make([]indexEntry, 0, idx.count())
+
+	for _, entry := range idx.entries {
+		if _, ok := idx.pending[entry.id]; !ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	for _, entry := range idx.pending {
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func (idx *index) count() int {
+	return len(idx.entries) + len(idx.pending)
+}
+
+// id, size, location, pack and offset
+const indexRecordSize = len(chunkID{}) + 5 + len(xid.ID{}) + 4
+
+func encodeIndex(entries []indexEntry) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, len(entries)*indexRecordSize))
+
+	for _, entry := range entries {
+		buf.Write(entry.id[:])
+		binary.Write(buf, binary.LittleEndian, entry.size)
+		buf.WriteByte(entry.location)
+		buf.Write(entry.pack[:])
+		binary.Write(buf, binary.LittleEndian, entry.offset)
+	}
+
+	return buf.Bytes()
+}
+
+func decodeIndex(data []byte) ([]indexEntry, error) {
+	if len(data)%indexRecordSize != 0 {
+		return nil, errIndexFormat
+	}
+
+	entries := make([]indexEntry, 0, len(data)/indexRecordSize)
+
+	for offset := 0; offset < len(data); offset += indexRecordSize {
+		var entry indexEntry
+
+		record := data[offset : offset+indexRecordSize]
+
+		copy(entry.id[:], record)
+		entry.size = binary.LittleEndian.Uint32(record[len(chunkID{}):])
+		entry.location = record[len(chunkID{})+4]
+		copy(entry.pack[:], record[len(chunkID{})+5:])
+		entry.offset = binary.LittleEndian.Uint32(record[len(chunkID{})+5+len(xid.ID{}):])
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+const indexDir = "index"
+
+func (storage *Storage) indexPath(name string) string {
+	return path.Join(indexDir, name)
+}
+
+func (storage *Storage) readIndexFile(name string) ([]indexEntry, error) {
+	data, err := storage.backend.Get(storage.indexPath(name))
+	if err != nil {
+		return nil, ioError("read index", name, err)
+	}
+
+	if len(data) < len(indexMagic)+1 || string(data[:len(indexMagic)]) != indexMagic {
+		return nil, corruptedError("read index", name, errIndexFormat)
+	}
+
+	version := data[len(indexMagic)]
+	if version != indexVersion {
+		return nil, corruptedError("read index", name, fmt.Errorf("unsupported index version: %d", version))
+	}
+
+	plaintext, err := open(storage.key.Encrypt, data[len(indexMagic)+1:])
+	if err != nil {
+		return nil, corruptedError("read index", name, err)
+	}
+
+	raw, err := s2.Decode(nil, plaintext)
+	if err != nil {
+		return nil, corruptedError("read index", name, err)
+	}
+
+	entries, err := decodeIndex(raw)
+	if err != nil {
+		return nil, corruptedError("read index", name, err)
+	}
+
+	return entries, nil
+}
+
+func (storage *Storage) writeIndexFile(entries []indexEntry) (string, error) {
+	dst, err := seal(storage.key.Encrypt, s2.Encode(nil, encodeIndex(entries)))
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s.idx", xid.New().String())
+	content := append([]byte{}, indexMagic...)
+	content = append(content, indexVersion)
+	content = append(content, dst...)
+
+	if err := storage.backend.Put(storage.indexPath(name), content); err != nil {
+		return "", ioError("write index", name, err)
+	}
+
+	return name, nil
+}
+
+func (storage *Storage) listIndexFiles() ([]string, error) {
+	files, err := storage.backend.List(indexDir)
+	if err != nil {
+		return nil, ioError("list index", "", err)
+	}
+
+	var names []string
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name, ".idx") {
+			continue
+		}
+
+		names = append(names, path.Base(file.Name))
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (storage *Storage) loadIndex() error {
+	names, err := storage.listIndexFiles()
+	if err != nil {
+		return err
+	}
+
+	var entries []indexEntry
+
+	// A chunk stays where it was indexed first, a later index file, e.g.
+	// of an append-only client, cannot move it. Prune and rebuild-index
+	// remove the older files before their packs. The files are sorted by
+	// creation and appended newest first, so the entry kept by the single
+	// merge comes from the oldest file.
+	for i := len(names) - 1; i >= 0; i-- {
+		fileEntries, err := storage.readIndexFile(names[i])
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, fileEntries...)
+	}
+
+	storage.index.merge(entries)
+	storage.index.files = append(storage.index.files, names...)
+
+	return nil
+}
+
+// flushIndex finishes the open pack and writes the chunks added during
+// this run into a new index file.
+func (storage *Storage) flushIndex() error {
+	if storage.discard {
+		return nil
+	}
+
+	if err := storage.finishPack(); err != nil {
+		return err
+	}
+
+	return storage.flushPendingIndex()
+}
+
+// flushPendingIndex writes the index entries of all uploaded packs. The
+// entries stay visible as pending until they are merged afterwards.
+func (storage *Storage) flushPendingIndex() error {
+	storage.mu.Lock()
+	entries := storage.index.pendingEntries()
+	storage.mu.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	name, err := storage.writeIndexFile(entries)
+	if err != nil {
+		return err
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	storage.index.commit(entries)
+	storage.index.files = append(storage.index.files, name)
+
+	return nil
+}
+
+// RebuildIndex walks the pack and loose chunk trees and replaces all index
+// files with a single one describing the chunks found on disk.
+func (storage *Storage) RebuildIndex() (int, error) {
+	if err := storage.finishPack(); err != nil {
+		return 0, err
+	}
+
+	entries, err := storage.scanLooseChunks()
+	if err != nil {
+		return 0, err
+	}
+
+	packEntries, err := storage.scanPacks()
+	if err != nil {
+		return 0, err
+	}
+
+	entries = append(entries, packEntries...)
+
+	if err := storage.replaceIndex(entries); err != nil {
+		return 0, err
+	}
+
+	return len(entries), nil
+}
+
+// replaceIndex writes entries into a single new index file and removes all
+// previous index files afterwards.
+func (storage *Storage) replaceIndex(entries []indexEntry) error {
+	oldFiles, err := storage.listIndexFiles()
+	if err != nil {
+		return err
+	}
+
+	name, err := storage.writeIndexFile(entries)
+	if err != nil {
+		return err
+	}
+
+	for _, oldName := range oldFiles {
+		if err := storage.backend.Delete(storage.indexPath(oldName)); err != nil {
+			return ioError("delete index", oldName, err)
+		}
+	}
+
+	storage.index = newIndex()
+	storage.index.merge(entries)
+	storage.index.files = []string{name}
+
+	return nil
+}
+
+func (storage *Storage) scanLooseChunks() ([]indexEntry, error) {
+	var entries []indexEntry
+
+	files, err := storage.backend.List(".chunks")
+	if err != nil {
+		return nil, ioError("list chunks", "", err)
+	}
+
+	for _, file := range files {
+		base := path.Base(file.Name)
+
+		if !strings.HasSuffix(base, ".blob") {
+			continue
+		}
+
+		id, err := parseChunkID(strings.TrimSuffix(base, ".blob"))
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, indexEntry{
+			id:       id,
+			size:     uint32(file.Size),
+			location: locationLoose,
+		})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/xid"
+)
+
+func testEntry(id byte, pack xid.ID) indexEntry {
+	var chunk chunkID
+	chunk[0] = id
+
+	return indexEntry{id: chunk, location: locationPack, pack: pack}
+}
+
+func TestIndexMergeLaterWins(t *testing.T) {
+	oldPack, newPack := xid.New(), xid.New()
+
+	idx := newIndex()
+	idx.merge([]indexEntry{testEntry(3, oldPack), testEntry(1, oldPack), testEntry(2, oldPack)})
+	idx.merge([]indexEntry{testEntry(2, newPack), testEntry(4, newPack), testEntry(2, oldPack), testEntry(2, newPack)})
+
+	if len(idx.entries) != 4 {
+		t.Fatalf("got %d entries, want 4", len(idx.entries))
+	}
+
+	for i, entry := range idx.entries {
+		if int(entry.id[0]) != i+1 {
+			t.Fatalf("entry %d has id %d", i, entry.id[0])
+		}
+	}
+
+	if entry, _ := idx.get(testEntry(2, newPack).id); entry.pack != newPack {
+		t.Fatal("later entry did not replace the known one")
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	// every block writes its own index file
+	streams := [][]byte{randomData(1, 1<<20), randomData(2, 1<<20)}
+
+	var blocks []*Block
+	for _, data := range streams {
+		blocks = append(blocks, writeTestBlock(t, store, data))
+	}
+
+	count := store.index.count()
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	if len(store.index.files) != 2 || store.index.count() != count {
+		t.Fatalf("loaded %d files with %d entries, want 2 files with %d", len(store.index.files), store.index.count(), count)
+	}
+
+	for i, block := range blocks {
+		if got := restoreTestBlock(t, store, block, RestoreOptions{}); !bytes.Equal(got, streams[i]) {
+			t.Fatalf("block %d: restored data differs", i)
+		}
+	}
+}
+
+func TestLoadIndexKeepsFirstLocation(t *testing.T) {
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	data := randomData(1, 1<<20)
+	block := writeTestBlock(t, store, data)
+
+	id, err := parseChunkID(block.Blobs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	known, _ := store.index.get(id)
+
+	// a later index file moving a known chunk, e.g. from an append-only client
+	moved := known
+	moved.pack = xid.New()
+
+	if _, err := store.writeIndexFile([]indexEntry{moved}); err != nil {
+		t.Fatal(err)
+	}
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	if entry, _ := store.index.get(id); entry != known {
+		t.Fatal("a later index file moved a known chunk")
+	}
+
+	if got := restoreTestBlock(t, store, block, RestoreOptions{}); !bytes.Equal(got, data) {
+		t.Fatal("restored data differs")
+	}
+}
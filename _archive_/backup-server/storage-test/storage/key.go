@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keyFileName = "key"
+
+	scryptN = 1 << 17
+	scryptR = 8
+	scryptP = 1
+)
+
+type keyFile struct {
+	KDF     string
+	N       int
+	R       int
+	P       int
+	Salt    []byte
+	Data    []byte
+	Created int64
+}
+
+type masterKey struct {
+	Encrypt []byte
+	ChunkID []byte
+}
+
+func newMasterKey() (*masterKey, error) {
+	key := &masterKey{
+		Encrypt: make([]byte, 32),
+		ChunkID: make([]byte, 32),
+	}
+
+	if _, err := rand.Read(key.Encrypt); err != nil {
+		return nil, err
+	}
+
+	if _, err := rand.Read(key.ChunkID); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func deriveKey(password string, salt []byte, n, r, p int) ([]byte, error) {
+	return scrypt.Key([]byte(password), salt, n, r, p, 32)
+}
+
+// createKey writes a new repository key. A non-empty chunkIDKey is used
+// instead of a random one, so the repository shares chunk IDs with another.
+func (storage *Storage) createKey(password string, chunkIDKey []byte) (*masterKey, error) {
+	if len(password) == 0 {
+		return nil, errors.New("empty password")
+	}
+
+	key, err := newMasterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunkIDKey) > 0 {
+		copy(key.ChunkID, chunkIDKey)
+	}
+
+	file := keyFile{
+		KDF:     "scrypt",
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, 32),
+		Created: time.Now().UTC().Unix(),
+	}
+
+	if _, err := rand.Read(file.Salt); err != nil {
+		return nil, err
+	}
+
+	userKey, err := deriveKey(password, file.Salt, file.N, file.R, file.P)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Data, err = seal(userKey, data); err != nil {
+		return nil, err
+	}
+
+	content, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.backend.Put(keyFileName, content); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (storage *Storage) loadKey(password string) (*masterKey, error) {
+	content, err := storage.backend.Get(keyFileName)
+	if err != nil {
+		return nil, ioError("read repository key", "", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, corruptedError("read repository key", "", err)
+	}
+
+	if file.KDF != "scrypt" {
+		return nil, corruptedError("read repository key", "", errors.New("unsupported key derivation function: "+file.KDF))
+	}
+
+	userKey, err := deriveKey(password, file.Salt, file.N, file.R, file.P)
+	if err != nil {
+		return nil, corruptedError("read repository key", "", err)
+	}
+
+	data, err := open(userKey, file.Data)
+	if err != nil {
+		return nil, corruptedError("open repository key", "", err)
+	}
+
+	var key masterKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, corruptedError("open repository key", "", err)
+	}
+
+	return &key, nil
+}
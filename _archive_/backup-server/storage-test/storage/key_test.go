@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenRequiresInit(t *testing.T) {
+	path := t.TempDir()
+
+	if _, err := New(StorageConfig{Path: path, Password: testPassword}); !errors.Is(err, ErrNotInitialized) {
+		t.Fatalf("got %v, want ErrNotInitialized", err)
+	}
+
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(StorageConfig{Path: path, Password: testPassword, Create: true}); !errors.Is(err, ErrExists) {
+		t.Fatalf("got %v, want ErrExists", err)
+	}
+}
+
+func TestCreateRefusesNonEmpty(t *testing.T) {
+	path := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(path, "data.txt"), []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(StorageConfig{Path: path, Password: testPassword, Create: true}); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("got %v, want ErrNotEmpty", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(path, keyFileName)); !os.IsNotExist(err) {
+		t.Fatal("key was written")
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	path := t.TempDir()
+
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(StorageConfig{Path: path, Password: "wrong"}); err == nil {
+		t.Fatal("opened with a wrong password")
+	}
+}
+
+func TestStoredDataIsEncrypted(t *testing.T) {
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true, Compression: CompressionParams{Codec: CompressionNone}})
+
+	// a marker that would show up in chunks, blocks and index files
+	marker := []byte("plaintext marker 6b1f0c")
+	data := bytes.Repeat(append(randomData(1, 4096), marker...), 64)
+
+	block := writeTestBlock(t, store, data)
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Contains(content, marker) || bytes.Contains(content, []byte(block.ID)) {
+			t.Errorf("%s contains plaintext", name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := restoreTestBlock(t, store, block, RestoreOptions{}); !bytes.Equal(got, data) {
+		t.Fatal("restored data differs")
+	}
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// ListBlocks returns the IDs of all blocks in the repository.
+func (storage *Storage) ListBlocks() ([]string, error) {
+	return storage.listObjects("blocks")
+}
+
+// ListSnapshots returns the IDs of all snapshots in the repository.
+func (storage *Storage) ListSnapshots() ([]string, error) {
+	return storage.listObjects("snapshots")
+}
+
+func (storage *Storage) listObjects(name string) ([]string, error) {
+	var ids []string
+
+	files, err := storage.backend.List(name)
+	if err != nil {
+		return nil, ioError("list "+name, "", err)
+	}
+
+	for _, file := range files {
+		base := path.Base(file.Name)
+
+		if !strings.HasSuffix(base, ".dat") {
+			continue
+		}
+
+		ids = append(ids, strings.TrimSuffix(base, ".dat"))
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/rs/xid"
+)
+
+type LockMode int
+
+const (
+	LockNone LockMode = iota
+	// LockShared is held by backup and restore, any number of shared
+	// locks may exist at the same time.
+	LockShared
+	// LockExclusive is held by maintenance commands like prune.
+	LockExclusive
+)
+
+const (
+	lockRefreshInterval = 5 * time.Minute
+	// locks not refreshed for this long belong to a dead process
+	lockStaleTimeout = 30 * time.Minute
+)
+
+// Lock is stored as locks/<id>.dat while a process uses the repository.
+type Lock struct {
+	ID        string `json:"-"`
+	Hostname  string `json:"hostname"`
+	Username  string `json:"username"`
+	PID       int    `json:"pid"`
+	Exclusive bool   `json:"exclusive"`
+	Created   int64  `json:"created"`
+	Refreshed int64  `json:"refreshed"`
+}
+
+type repoLock struct {
+	lock *Lock
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Stale reports whether the lock owner is gone: the lock was not refreshed
+// in time, or it was created on this host by a process that no longer runs.
+func (lock *Lock) Stale() bool {
+	if time.Since(time.Unix(lock.Refreshed, 0)) > lockStaleTimeout {
+		return true
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return false
+	}
+
+	return lock.Hostname == hostname && !processExists(lock.PID)
+}
+
+func (lock *Lock) String() string {
+	mode := "shared"
+	if lock.Exclusive {
+		mode = "exclusive"
+	}
+
+	return fmt.Sprintf(
+		"%s lock %s by %s@%s pid %d, created %s",
+		mode, lock.ID, lock.Username, lock.Hostname, lock.PID,
+		time.Unix(lock.Created, 0).Local().Format(time.RFC3339),
+	)
+}
+
+func lockedError(lock *Lock) error {
+	return &Error{
+		Kind: KindLocked,
+		Op:   "lock repository",
+		Err:  fmt.Errorf("repository is already locked: %s, run unlock if the lock is stale", lock),
+	}
+}
+
+func (storage *Storage) lockPath(id string) string {
+	return path.Join("locks", fmt.Sprintf("%s.dat", id))
+}
+
+func (storage *Storage) writeLock(lock *Lock) error {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	dst, err := seal(storage.key.Encrypt, s2.EncodeBest(nil, data))
+	if err != nil {
+		return err
+	}
+
+	return ioError("write lock", lock.ID, storage.backend.Put(storage.lockPath(lock.ID), dst))
+}
+
+// ListLocks returns all locks of the repository. Locks removed while
+// listing are skipped.
+func (storage *Storage) ListLocks() ([]*Lock, error) {
+	ids, err := storage.listObjects("locks")
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make([]*Lock, 0, len(ids))
+
+	for _, id := range ids {
+		var lock Lock
+
+		if err := storage.readObject("read lock", id, storage.lockPath(id), &lock); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		lock.ID = id
+		locks = append(locks, &lock)
+	}
+
+	return locks, nil
+}
+
+// checkLocks fails when a live lock of another process conflicts with the
+// requested mode.
+func (storage *Storage) checkLocks(exclusive bool, ownID string) error {
+	locks, err := storage.ListLocks()
+	if err != nil {
+		return err
+	}
+
+	for _, lock := range locks {
+		if lock.ID == ownID || lock.Stale() {
+			continue
+		}
+
+		if exclusive || lock.Exclusive {
+			return lockedError(lock)
+		}
+	}
+
+	return nil
+}
+
+// lock creates the lock object and keeps it refreshed until unlock. The
+// locks are checked again after writing, so of two processes racing for
+// conflicting locks at least one gives up.
+func (storage *Storage) lock(mode LockMode) error {
+	if mode == LockNone {
+		return nil
+	}
+
+	exclusive := mode == LockExclusive
+
+	if err := storage.checkLocks(exclusive, ""); err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+
+	var username string
+	if current, err := user.Current(); err == nil {
+		username = current.Username
+	}
+
+	now := time.Now().UTC().Unix()
+
+	lock := &Lock{
+		ID:        xid.New().String(),
+		Hostname:  hostname,
+		Username:  username,
+		PID:       os.Getpid(),
+		Exclusive: exclusive,
+		Created:   now,
+		Refreshed: now,
+	}
+
+	if err := storage.writeLock(lock); err != nil {
+		return err
+	}
+
+	if err := storage.checkLocks(exclusive, lock.ID); err != nil {
+		storage.backend.Delete(storage.lockPath(lock.ID))
+		return err
+	}
+
+	storage.repoLock = &repoLock{
+		lock: lock,
+		stop: make(chan struct{}),
+	}
+
+	storage.repoLock.wg.Add(1)
+
+	go storage.refreshLock(storage.repoLock)
+
+	return nil
+}
+
+func (storage *Storage) refreshLock(held *repoLock) {
+	defer held.wg.Done()
+
+	ticker := time.NewTicker(lockRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-held.stop:
+			return
+
+		case <-ticker.C:
+			held.lock.Refreshed = time.Now().UTC().Unix()
+
+			if err := storage.writeLock(held.lock); err != nil {
+				log.Printf("refresh lock: %s", err)
+			}
+		}
+	}
+}
+
+func (storage *Storage) unlock() error {
+	held := storage.repoLock
+	if held == nil {
+		return nil
+	}
+
+	storage.repoLock = nil
+
+	close(held.stop)
+	held.wg.Wait()
+
+	return ioError("remove lock", held.lock.ID, storage.backend.Delete(storage.lockPath(held.lock.ID)))
+}
+
+// RemoveLocks deletes stale locks, or all locks of other processes when all
+// is set, and returns how many were removed.
+func (storage *Storage) RemoveLocks(all bool) (int, error) {
+	locks, err := storage.ListLocks()
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+
+	for _, lock := range locks {
+		if storage.repoLock != nil && lock.ID == storage.repoLock.lock.ID {
+			continue
+		}
+
+		if !all && !lock.Stale() {
+			continue
+		}
+
+		if err := storage.backend.Delete(storage.lockPath(lock.ID)); err != nil {
+			return removed, ioError("remove lock", lock.ID, err)
+		}
+
+		log.Printf("removed %s", lock)
+
+		removed++
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package storage
+
+// without a portable check the process is assumed to run, such locks only
+// become stale by their refresh time
+func processExists(pid int) bool {
+	return true
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// writeTestLock stores a lock of another process, refreshed age ago.
+func writeTestLock(t *testing.T, store *Storage, hostname string, pid int, exclusive bool, age time.Duration) *Lock {
+	t.Helper()
+
+	refreshed := time.Now().Add(-age).Unix()
+
+	lock := &Lock{
+		ID:        xid.New().String(),
+		Hostname:  hostname,
+		Username:  "test",
+		PID:       pid,
+		Exclusive: exclusive,
+		Created:   refreshed,
+		Refreshed: refreshed,
+	}
+
+	if err := store.writeLock(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	return lock
+}
+
+// deadPID returns the PID of a process that has exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+
+	if processExists(cmd.Process.Pid) {
+		t.Skip("no process check on this platform")
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestLockConflicts(t *testing.T) {
+	path := t.TempDir()
+	newTestStorage(t, StorageConfig{Path: path, Create: true}).Close()
+
+	open := func(mode LockMode) (*Storage, error) {
+		return New(StorageConfig{Path: path, Password: testPassword, Lock: mode})
+	}
+
+	first, err := open(LockShared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := open(LockShared)
+	if err != nil {
+		t.Fatalf("second shared lock: %v", err)
+	}
+
+	if _, err := open(LockExclusive); !errors.Is(err, ErrLocked) {
+		t.Fatalf("exclusive lock next to shared ones: got %v, want ErrLocked", err)
+	}
+
+	for _, store := range []*Storage{first, second} {
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exclusive, err := open(LockExclusive)
+	if err != nil {
+		t.Fatalf("exclusive lock after unlock: %v", err)
+	}
+
+	for _, mode := range []LockMode{LockShared, LockExclusive} {
+		if _, err := open(mode); !errors.Is(err, ErrLocked) {
+			t.Fatalf("lock mode %d next to an exclusive lock: got %v, want ErrLocked", mode, err)
+		}
+	}
+
+	// the lock is removed on close
+	if err := exclusive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := open(LockNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	if locks, err := store.ListLocks(); err != nil || len(locks) != 0 {
+		t.Fatalf("got locks %v, %v", locks, err)
+	}
+}
+
+func TestStaleLocks(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead := deadPID(t)
+
+	store := newTestStorage(t, StorageConfig{})
+	defer store.Close()
+
+	tests := []struct {
+		name  string
+		lock  *Lock
+		stale bool
+	}{
+		{"live process", writeTestLock(t, store, hostname, os.Getpid(), true, 0), false},
+		{"dead process", writeTestLock(t, store, hostname, dead, true, 0), true},
+		{"other host", writeTestLock(t, store, "other-host", dead, true, 0), false},
+		{"refreshed recently", writeTestLock(t, store, "other-host", dead, true, lockStaleTimeout/2), false},
+		{"not refreshed", writeTestLock(t, store, "other-host", dead, true, 2*lockStaleTimeout), true},
+		{"not refreshed live process", writeTestLock(t, store, hostname, os.Getpid(), true, 2*lockStaleTimeout), true},
+	}
+
+	for _, test := range tests {
+		if stale := test.lock.Stale(); stale != test.stale {
+			t.Fatalf("%s: stale %v, want %v", test.name, stale, test.stale)
+		}
+	}
+
+	locks, err := store.ListLocks()
+	if err != nil || len(locks) != len(tests) {
+		t.Fatalf("got %d locks, %v", len(locks), err)
+	}
+
+	for _, lock := range locks {
+		for _, test := range tests {
+			if lock.ID == test.lock.ID && lock.Stale() != test.stale {
+				t.Fatalf("%s: stale %v after reading", test.name, lock.Stale())
+			}
+		}
+	}
+}
+
+func TestStaleLockIgnored(t *testing.T) {
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	writeTestLock(t, store, "other-host", 1, true, 2*lockStaleTimeout)
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := New(StorageConfig{Path: path, Password: testPassword, Lock: LockExclusive})
+	if err != nil {
+		t.Fatalf("stale lock blocks: %v", err)
+	}
+
+	store.Close()
+}
+
+func TestRemoveLocks(t *testing.T) {
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	live := writeTestLock(t, store, "other-host", 1, false, 0)
+	writeTestLock(t, store, "other-host", 1, true, 2*lockStaleTimeout)
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := New(StorageConfig{Path: path, Password: testPassword, Lock: LockShared})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	ids := func() map[string]bool {
+		locks, err := store.ListLocks()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make(map[string]bool)
+		for _, lock := range locks {
+			ids[lock.ID] = true
+		}
+
+		return ids
+	}
+
+	own := store.repoLock.lock.ID
+
+	if removed, err := store.RemoveLocks(false); err != nil || removed != 1 {
+		t.Fatalf("removed %d stale locks, %v", removed, err)
+	}
+
+	if got := ids(); len(got) != 2 || !got[own] || !got[live.ID] {
+		t.Fatalf("got locks %v after removing stale ones", got)
+	}
+
+	// all removes the locks of other processes, never the own one
+	if removed, err := store.RemoveLocks(true); err != nil || removed != 1 {
+		t.Fatalf("removed %d locks, %v", removed, err)
+	}
+
+	if got := ids(); len(got) != 1 || !got[own] {
+		t.Fatalf("got locks %v after removing all", got)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import (
+	"errors"
+	"syscall"
+)
+
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
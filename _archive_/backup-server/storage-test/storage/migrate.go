@@ -0,0 +1,23 @@
+package storage
+
+type MigrateReport struct {
+	FromVersion int
+	ToVersion   int
+}
+
+// FormatVersion returns the format version of the repository.
+func (storage *Storage) FormatVersion() int {
+	return storage.config.Version
+}
+
+// Migrate upgrades the repository in place to the current format version.
+// Version 1 is the first format, there is nothing to upgrade yet; later
+// versions add their upgrade steps here. Requires the exclusive lock.
+func (storage *Storage) Migrate() (*MigrateReport, error) {
+	report := &MigrateReport{
+		FromVersion: storage.config.Version,
+		ToVersion:   configVersion,
+	}
+
+	return report, nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd
+
+package storage
+
+import "os"
+
+func fillNodeOwner(node *Node, info os.FileInfo) {}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+func fillNodeOwner(node *Node, info os.FileInfo) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		node.UID = stat.Uid
+		node.GID = stat.Gid
+	}
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rs/xid"
+)
+
+const (
+	packTargetSize = 16 << 20 // 16 MB
+
+	packHeaderRecordSize = 32 + 4 + 4
+)
+
+var errPackFormat = errors.New("invalid pack file format")
+
+// pack collects encrypted chunks until it reaches packTargetSize. On disk a
+// pack is the concatenation of chunks followed by an encrypted header
+// listing chunk ID/offset/length and the header length as uint32.
+type pack struct {
+	id      xid.ID
+	buf     bytes.Buffer
+	entries []indexEntry
+}
+
+func newPack() *pack {
+	return &pack{
+		id: xid.New(),
+	}
+}
+
+func (current *pack) add(id chunkID, data []byte) {
+	current.entries = append(current.entries, indexEntry{
+		id:       id,
+		size:     uint32(len(data)),
+		location: locationPack,
+		pack:     current.id,
+		offset:   uint32(current.buf.Len()),
+	})
+
+	current.buf.Write(data)
+}
+
+func (storage *Storage) getPackPath(id xid.ID) string {
+	name := id.String()
+	return path.Join("packs", name[0:4], fmt.Sprintf("%s.pack", name))
+}
+
+// storeChunk appends an encrypted chunk to the open pack. Full packs are
+// uploaded by the calling goroutine, so several callers upload in parallel.
+func (storage *Storage) storeChunk(id chunkID, data []byte) error {
+	storage.writeLimit.wait(len(data))
+
+	storage.mu.Lock()
+
+	if storage.pack == nil {
+		storage.pack = newPack()
+	}
+
+	storage.pack.add(id, data)
+
+	var full *pack
+
+	if storage.pack.buf.Len() >= packTargetSize {
+		full = storage.pack
+		storage.pack = nil
+	}
+
+	storage.mu.Unlock()
+
+	if full == nil {
+		return nil
+	}
+
+	return storage.uploadPack(full)
+}
+
+func (storage *Storage) finishPack() error {
+	storage.mu.Lock()
+	current := storage.pack
+	storage.pack = nil
+	storage.mu.Unlock()
+
+	if current == nil || len(current.entries) == 0 {
+		return nil
+	}
+
+	return storage.uploadPack(current)
+}
+
+// uploadPack writes the pack with its header and only then publishes the
+// chunks in the index.
+func (storage *Storage) uploadPack(current *pack) error {
+	if err := storage.writePack(current); err != nil {
+		return err
+	}
+
+	storage.mu.Lock()
+
+	for _, entry := range current.entries {
+		storage.index.add(entry)
+		delete(storage.inflight, entry.id)
+	}
+
+	flush := len(storage.index.pending) >= indexFlushEntries
+
+	storage.mu.Unlock()
+
+	if flush {
+		return storage.flushPendingIndex()
+	}
+
+	return nil
+}
+
+// writePack appends the encrypted header to the pack and stores it.
+func (storage *Storage) writePack(current *pack) error {
+	header := bytes.NewBuffer(make([]byte, 0, len(current.entries)*packHeaderRecordSize))
+
+	for _, entry := range current.entries {
+		header.Write(entry.id[:])
+		binary.Write(header, binary.LittleEndian, entry.offset)
+		binary.Write(header, binary.LittleEndian, entry.size)
+	}
+
+	sealed, err := seal(storage.key.Encrypt, header.Bytes())
+	if err != nil {
+		return err
+	}
+
+	current.buf.Write(sealed)
+	binary.Write(&current.buf, binary.LittleEndian, uint32(len(sealed)))
+
+	if err := storage.backend.Put(storage.getPackPath(current.id), current.buf.Bytes()); err != nil {
+		return ioError("write pack", current.id.String(), err)
+	}
+
+	return nil
+}
+
+func (storage *Storage) readPackChunk(entry indexEntry) ([]byte, error) {
+	data, err := storage.backend.GetRange(storage.getPackPath(entry.pack), int64(entry.offset), int64(entry.size))
+	if err != nil {
+		return nil, ioError("read pack", entry.pack.String(), err)
+	}
+
+	return data, nil
+}
+
+func (storage *Storage) readPackHeader(id xid.ID) ([]indexEntry, error) {
+	name := storage.getPackPath(id)
+
+	info, err := storage.backend.Stat(name)
+	if err != nil {
+		return nil, ioError("read pack", id.String(), err)
+	}
+
+	if info.Size < 4 {
+		return nil, corruptedError("read pack", id.String(), errPackFormat)
+	}
+
+	trailer, err := storage.backend.GetRange(name, info.Size-4, 4)
+	if err != nil {
+		return nil, ioError("read pack", id.String(), err)
+	}
+
+	headerSize := int64(binary.LittleEndian.Uint32(trailer))
+
+	if headerSize+4 > info.Size {
+		return nil, corruptedError("read pack", id.String(), errPackFormat)
+	}
+
+	sealed, err := storage.backend.GetRange(name, info.Size-4-headerSize, headerSize)
+	if err != nil {
+		return nil, ioError("read pack", id.String(), err)
+	}
+
+	header, err := open(storage.key.Encrypt, sealed)
+	if err != nil {
+		return nil, corruptedError("read pack", id.String(), err)
+	}
+
+	if len(header)%packHeaderRecordSize != 0 {
+		return nil, corruptedError("read pack", id.String(), errPackFormat)
+	}
+
+	entries := make([]indexEntry, 0, len(header)/packHeaderRecordSize)
+
+	for offset := 0; offset < len(header); offset += packHeaderRecordSize {
+		entry := indexEntry{
+			location: locationPack,
+			pack:     id,
+		}
+
+		copy(entry.id[:], header[offset:])
+		entry.offset = binary.LittleEndian.Uint32(header[offset+32:])
+		entry.size = binary.LittleEndian.Uint32(header[offset+36:])
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (storage *Storage) listPacks() ([]xid.ID, error) {
+	var packs []xid.ID
+
+	files, err := storage.backend.List("packs")
+	if err != nil {
+		return nil, ioError("list packs", "", err)
+	}
+
+	for _, file := range files {
+		base := path.Base(file.Name)
+
+		if !strings.HasSuffix(base, ".pack") {
+			continue
+		}
+
+		id, err := xid.FromString(strings.TrimSuffix(base, ".pack"))
+		if err != nil {
+			continue
+		}
+
+		packs = append(packs, id)
+	}
+
+	return packs, nil
+}
+
+func (storage *Storage) scanPacks() ([]indexEntry, error) {
+	packs, err := storage.listPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []indexEntry
+
+	for _, id := range packs {
+		packEntries, err := storage.readPackHeader(id)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, packEntries...)
+	}
+
+	return entries, nil
+}
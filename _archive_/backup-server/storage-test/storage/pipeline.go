@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultUploaders = 2
+	defaultMaxMemory = 256 << 20 // 256 MB
+)
+
+type pipelineChunk struct {
+	seq    int
+	data   []byte
+	offset uint
+	id     chunkID
+	sealed []byte
+}
+
+type pipelineResult struct {
+	seq    int
+	blob   Blob
+	writed bool
+	stored int
+}
+
+// memoryLimit is a byte semaphore bounding the chunk data in flight.
+type memoryLimit struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int64
+	used    int64
+	aborted bool
+}
+
+func newMemoryLimit(limit int64) *memoryLimit {
+	mem := &memoryLimit{limit: limit}
+	mem.cond = sync.NewCond(&mem.mu)
+
+	return mem
+}
+
+// acquire blocks until size bytes are available, it returns false once the
+// pipeline was aborted.
+func (mem *memoryLimit) acquire(size int64) bool {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	// a single chunk larger than the limit is still let through alone
+	for !mem.aborted && mem.used > 0 && mem.used+size > mem.limit {
+		mem.cond.Wait()
+	}
+
+	mem.used += size
+
+	return !mem.aborted
+}
+
+func (mem *memoryLimit) abort() {
+	mem.mu.Lock()
+	mem.aborted = true
+	mem.mu.Unlock()
+
+	mem.cond.Broadcast()
+}
+
+func (mem *memoryLimit) release(size int64) {
+	mem.mu.Lock()
+	mem.used -= size
+	mem.mu.Unlock()
+
+	mem.cond.Broadcast()
+}
+
+// writeStreamParallel is the concurrent variant of writeStream: the calling
+// goroutine chunks the stream and computes the stream checksum, workers
+// hash, compress and encrypt chunks and uploaders append them to packs.
+// Blob order is restored from the chunk sequence numbers.
+func (storage *Storage) writeStreamParallel(st *stream, reader io.Reader, buf []byte, stats *writeStats) error {
+	group, ctx := errgroup.WithContext(context.Background())
+
+	mem := newMemoryLimit(storage.maxMemory)
+
+	go func() {
+		<-ctx.Done()
+		mem.abort()
+	}()
+
+	chunks := make(chan *pipelineChunk, storage.workers)
+	uploads := make(chan *pipelineChunk, storage.uploaders)
+	results := make(chan pipelineResult, storage.workers)
+
+	var workers sync.WaitGroup
+
+	for i := 0; i < storage.workers; i++ {
+		workers.Add(1)
+
+		group.Go(func() error {
+			defer workers.Done()
+
+			for chunk := range chunks {
+				chunk.id = storage.hasher.sum(chunk.data)
+
+				claimed := storage.claimChunk(chunk.id)
+
+				result := pipelineResult{
+					seq: chunk.seq,
+					blob: Blob{
+						ID:     chunk.id.String(),
+						Offset: chunk.offset,
+						Length: uint(len(chunk.data)),
+					},
+					writed: claimed,
+				}
+
+				if claimed && !storage.discard {
+					sealed, err := storage.encodeChunk(chunk.data)
+					if err != nil {
+						return err
+					}
+
+					chunk.sealed = sealed
+					result.stored = len(sealed)
+
+					select {
+					case uploads <- chunk:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+
+				} else {
+					mem.release(int64(len(chunk.data)))
+				}
+
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			return nil
+		})
+	}
+
+	go func() {
+		workers.Wait()
+		close(uploads)
+		close(results)
+	}()
+
+	for i := 0; i < storage.uploaders; i++ {
+		group.Go(func() error {
+			for chunk := range uploads {
+				if err := storage.storeChunk(chunk.id, chunk.sealed); err != nil {
+					return err
+				}
+
+				mem.release(int64(len(chunk.data)))
+			}
+
+			return nil
+		})
+	}
+
+	base := st.size
+
+	group.Go(func() error {
+		// results arrive out of order, blobs are appended once contiguous
+		pending := make(map[int]Blob)
+		next := 0
+
+		for result := range results {
+			pending[result.seq] = result.blob
+
+			for blob, ok := pending[next]; ok; blob, ok = pending[next] {
+				st.blobs = append(st.blobs, blob)
+				delete(pending, next)
+				next++
+			}
+
+			stats.addChunk(uint64(result.blob.Length), result.writed, result.stored)
+
+			if err := st.maybeCheckpoint(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	readErr := func() error {
+		defer close(chunks)
+
+		fileChunker := storage.newChunker(reader)
+
+		for seq := 0; ; seq++ {
+			chunk, err := fileChunker.Next(buf)
+			if err == io.EOF {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			st.checksum.Write(chunk.Data)
+			st.size += uint64(chunk.Length)
+
+			if !mem.acquire(int64(chunk.Length)) {
+				return nil
+			}
+
+			job := &pipelineChunk{
+				seq:    seq,
+				data:   append([]byte(nil), chunk.Data...),
+				offset: uint(base) + chunk.Start,
+			}
+
+			select {
+			case chunks <- job:
+			case <-ctx.Done():
+				mem.release(int64(chunk.Length))
+				return nil
+			}
+		}
+	}()
+
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	return readErr
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/minio/highwayhash"
+	"github.com/vitalvas/backup-server/storage-test/backend"
+)
+
+// The first versions stored unencrypted s2 chunks in .chunks/aa/bb/ID.blob
+// and s2 compressed JSON blocks in blocks/xxxx/ID.dat, without key and
+// config. Chunk IDs and block checksums are HighwayHash with a fixed key.
+var plainHashKey = []byte{
+	0xbc, 0x81, 0xd3, 0x01, 0x58, 0x91, 0x4b, 0xb9,
+	0x2b, 0x44, 0x8b, 0x32, 0xc9, 0x35, 0xea, 0xd7,
+	0x52, 0x33, 0x72, 0x7c, 0x20, 0xe1, 0xc1, 0x4f,
+	0xdf, 0xbe, 0xba, 0x04, 0x6b, 0x0e, 0x89, 0x48,
+}
+
+type ImportReport struct {
+	Blocks        int
+	SkippedBlocks int
+	Chunks        int
+	ChunksNew     int
+	Bytes         uint64
+	StoredBytes   uint64
+}
+
+// isPlainLayout reports whether the backend holds blocks of the unencrypted
+// layout, it is only called for locations without key.
+func isPlainLayout(store backend.Backend) (bool, error) {
+	files, err := store.List("blocks")
+	if err != nil {
+		return false, err
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file.Name, ".dat") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ImportPlain imports the blocks of an unencrypted repository of the first
+// versions at location. The content of every block is verified against its
+// chunk IDs and checksum and chunked again into the repository. Blocks keep
+// their ID and timestamp, blocks already present are skipped, so an
+// interrupted import resumes where it stopped. The source is not changed.
+func (storage *Storage) ImportPlain(location string) (*ImportReport, error) {
+	source, err := backend.Open(location, "")
+	if err != nil {
+		return nil, ioError("open repository", location, err)
+	}
+
+	defer source.Close()
+
+	files, err := source.List("blocks")
+	if err != nil {
+		return nil, ioError("list blocks", "", err)
+	}
+
+	report := &ImportReport{}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name, ".dat") {
+			continue
+		}
+
+		id := strings.TrimSuffix(path.Base(file.Name), ".dat")
+
+		if err := validateObjectID(id); err != nil {
+			return nil, corruptedError("import block", id, err)
+		}
+
+		_, err := storage.backend.Stat(storage.blockPath(id))
+		if err == nil {
+			report.SkippedBlocks++
+			continue
+		}
+
+		if !errors.Is(err, backend.ErrNotExist) {
+			return nil, ioError("import block", id, err)
+		}
+
+		if err := storage.importPlainBlock(source, file.Name, report); err != nil {
+			return nil, err
+		}
+
+		report.Blocks++
+	}
+
+	return report, nil
+}
+
+func (storage *Storage) importPlainBlock(source backend.Backend, name string, report *ImportReport) error {
+	legacy, err := readPlainBlock(source, name)
+	if err != nil {
+		return err
+	}
+
+	reader, pipe := io.Pipe()
+
+	go func() {
+		pipe.CloseWithError(streamPlainBlock(pipe, source, legacy))
+	}()
+
+	stats := newWriteStats(nil)
+	st := storage.newStream()
+
+	err = storage.writeStreamTo(st, reader, nil, stats)
+
+	reader.CloseWithError(io.ErrClosedPipe)
+
+	if err != nil {
+		return err
+	}
+
+	block := &Block{
+		ID:        legacy.ID,
+		Blobs:     st.blobs,
+		Size:      st.size,
+		CheckSum:  hex.EncodeToString(st.checksum.Sum(nil)),
+		Timestamp: legacy.Timestamp,
+		ChunkHash: storage.config.ChunkHash,
+	}
+
+	if err := storage.flushIndex(); err != nil {
+		return err
+	}
+
+	if err := storage.writeBlock(block); err != nil {
+		return err
+	}
+
+	report.Chunks += int(stats.Chunks)
+	report.ChunksNew += int(stats.ChunksNew)
+	report.Bytes += stats.BytesRead
+	report.StoredBytes += stats.BytesStored
+
+	return nil
+}
+
+func readPlainBlock(source backend.Backend, name string) (*Block, error) {
+	id := strings.TrimSuffix(path.Base(name), ".dat")
+
+	data, err := source.Get(name)
+	if err != nil {
+		return nil, ioError("read block", id, err)
+	}
+
+	raw, err := s2.Decode(nil, data)
+	if err != nil {
+		return nil, corruptedError("read block", id, err)
+	}
+
+	var block Block
+
+	if err := json.Unmarshal(raw, &block); err != nil {
+		return nil, corruptedError("read block", id, err)
+	}
+
+	if err := validateObjectID(block.ID); err != nil || block.ID != id {
+		return nil, corruptedError("read block", id, fmt.Errorf("block has id %q", block.ID))
+	}
+
+	return &block, nil
+}
+
+// streamPlainBlock writes the verified content of a block of the
+// unencrypted layout.
+func streamPlainBlock(writer io.Writer, source backend.Backend, block *Block) error {
+	checksum, err := highwayhash.New(plainHashKey)
+	if err != nil {
+		return err
+	}
+
+	var size uint64
+
+	for _, blob := range block.Blobs {
+		if uint64(blob.Offset) != size || len(blob.ID) < 4 {
+			return corruptedError("read block", block.ID, fmt.Errorf("invalid blob %s at offset %d", blob.ID, blob.Offset))
+		}
+
+		data, err := source.Get(path.Join(".chunks", blob.ID[0:2], blob.ID[2:4], fmt.Sprintf("%s.blob", blob.ID)))
+		if err != nil {
+			return ioError("read chunk", blob.ID, err)
+		}
+
+		content, err := s2.Decode(nil, data)
+		if err != nil {
+			return corruptedError("read chunk", blob.ID, err)
+		}
+
+		sum := highwayhash.Sum(content, plainHashKey)
+		if hex.EncodeToString(sum[:]) != blob.ID || uint(len(content)) != blob.Length {
+			return corruptedError("read chunk", blob.ID, errors.New("content does not match the chunk id"))
+		}
+
+		checksum.Write(content)
+		size += uint64(len(content))
+
+		if _, err := writer.Write(content); err != nil {
+			return err
+		}
+	}
+
+	if size != block.Size || hex.EncodeToString(checksum.Sum(nil)) != block.CheckSum {
+		return corruptedError("read block", block.ID, errors.New("checksum mismatch"))
+	}
+
+	return nil
+}
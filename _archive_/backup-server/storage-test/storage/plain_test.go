@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/minio/highwayhash"
+)
+
+// writePlainBlock stores data in the unencrypted layout, split into fixed
+// size chunks.
+func writePlainBlock(t *testing.T, dir string, data []byte, timestamp int64) *Block {
+	t.Helper()
+
+	block := NewBlock()
+	block.Timestamp = timestamp
+
+	checksum, err := highwayhash.New(plainHashKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for offset := 0; offset < len(data); offset += 50 << 10 {
+		end := offset + 50<<10
+		if end > len(data) {
+			end = len(data)
+		}
+
+		sum := highwayhash.Sum(data[offset:end], plainHashKey)
+		id := hex.EncodeToString(sum[:])
+
+		name := filepath.Join(dir, ".chunks", id[0:2], id[2:4], fmt.Sprintf("%s.blob", id))
+		writePlainFile(t, name, s2.Encode(nil, data[offset:end]))
+
+		checksum.Write(data[offset:end])
+
+		block.WriteBlob(Blob{ID: id, Offset: uint(offset), Length: uint(end - offset)})
+		block.Size += uint64(end - offset)
+	}
+
+	block.CheckSum = hex.EncodeToString(checksum.Sum(nil))
+
+	raw, err := json.Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePlainFile(t, filepath.Join(dir, "blocks", block.ID[0:4], fmt.Sprintf("%s.dat", block.ID)), s2.EncodeBest(nil, raw))
+
+	return block
+}
+
+func writePlainFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(name, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportPlain(t *testing.T) {
+	plain := t.TempDir()
+
+	first := randomData(1, 200<<10)
+	second := append(randomData(2, 100<<10), first[:120<<10]...)
+
+	blocks := []*Block{
+		writePlainBlock(t, plain, first, 1656633600),
+		writePlainBlock(t, plain, second, 1656720000),
+	}
+
+	if _, err := New(StorageConfig{Path: plain, Password: testPassword}); !errors.Is(err, ErrPlainRepository) {
+		t.Fatalf("got %v, want ErrPlainRepository", err)
+	}
+
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	report, err := store.ImportPlain(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Blocks != 2 || report.Bytes != uint64(len(first)+len(second)) {
+		t.Fatalf("imported %d blocks, %d bytes", report.Blocks, report.Bytes)
+	}
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	for i, data := range [][]byte{first, second} {
+		block, err := store.GetBlock(blocks[i].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if block.Timestamp != blocks[i].Timestamp || block.Size != uint64(len(data)) {
+			t.Fatalf("block %s: timestamp %d, size %d", block.ID, block.Timestamp, block.Size)
+		}
+
+		if got := restoreTestBlock(t, store, block, RestoreOptions{}); string(got) != string(data) {
+			t.Fatalf("block %s: restored data differs", block.ID)
+		}
+	}
+
+	// a second run resumes and skips the imported blocks
+	report, err = store.ImportPlain(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Blocks != 0 || report.SkippedBlocks != 2 {
+		t.Fatalf("imported %d blocks, skipped %d", report.Blocks, report.SkippedBlocks)
+	}
+}
+
+func TestImportPlainCorrupted(t *testing.T) {
+	plain := t.TempDir()
+
+	block := writePlainBlock(t, plain, randomData(1, 120<<10), 1656633600)
+
+	// a chunk whose content does not match its ID
+	id := block.Blobs[1].ID
+	writePlainFile(t, filepath.Join(plain, ".chunks", id[0:2], id[2:4], fmt.Sprintf("%s.blob", id)), s2.Encode(nil, randomData(2, int(block.Blobs[1].Length))))
+
+	store := newTestStorage(t, StorageConfig{})
+	defer store.Close()
+
+	if _, err := store.ImportPlain(plain); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("got %v, want ErrCorrupted", err)
+	}
+
+	if _, err := store.GetBlock(block.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("corrupted block was imported: %v", err)
+	}
+}
+
+func TestImportPlainInvalidName(t *testing.T) {
+	plain := t.TempDir()
+
+	writePlainFile(t, filepath.Join(plain, "blocks", "abc.dat"), []byte("{}"))
+
+	store := newTestStorage(t, StorageConfig{})
+	defer store.Close()
+
+	if _, err := store.ImportPlain(plain); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("got %v, want ErrCorrupted", err)
+	}
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/rs/xid"
+	"github.com/vitalvas/backup-server/storage-test/backend"
+)
+
+type PruneReport struct {
+	TotalChunks        int
+	TotalBytes         uint64
+	UnreferencedChunks int
+	UnreferencedBytes  uint64
+	RemovedPacks       int
+	RepackedPacks      int
+	RemovedLoose       int
+}
+
+type packUsage struct {
+	used   []indexEntry
+	unused []indexEntry
+}
+
+// referencedChunks collects the IDs of every chunk reachable from the
+// blocks, checkpoints and snapshots stored in the repository.
+func (storage *Storage) referencedChunks() (map[chunkID]struct{}, error) {
+	used := make(map[chunkID]struct{})
+
+	mark := func(id string) error {
+		chunk, err := parseChunkID(id)
+		if err != nil {
+			return err
+		}
+
+		used[chunk] = struct{}{}
+
+		return nil
+	}
+
+	blocks, err := storage.ListBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range blocks {
+		block, err := storage.GetBlock(id)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, blob := range block.Blobs {
+			if err := mark(blob.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// interrupted backups keep their chunks until resumed or removed
+	checkpoints, err := storage.ListCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range checkpoints {
+		checkpoint, err := storage.GetCheckpoint(id)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, blob := range checkpoint.Blobs {
+			if err := mark(blob.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	snapshots, err := storage.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range snapshots {
+		snapshot, err := storage.GetSnapshot(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := mark(snapshot.Tree); err != nil {
+			return nil, err
+		}
+
+		err = storage.WalkTree(snapshot.Tree, "/", func(_ string, node *Node) error {
+			if node.Type == NodeTypeDir {
+				return mark(node.Subtree)
+			}
+
+			for _, blob := range node.Content {
+				if err := mark(blob.ID); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return used, nil
+}
+
+// Prune removes chunks that are no longer referenced by any block or
+// snapshot. Packs without live chunks are deleted, partially used packs are
+// rewritten with only the live chunks. With dryRun only the report is built.
+func (storage *Storage) Prune(dryRun bool) (*PruneReport, error) {
+	if err := storage.flushIndex(); err != nil {
+		return nil, err
+	}
+
+	used, err := storage.referencedChunks()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PruneReport{}
+
+	packs := make(map[xid.ID]*packUsage)
+
+	var loose []indexEntry
+
+	for _, entry := range storage.index.all() {
+		report.TotalChunks++
+		report.TotalBytes += uint64(entry.size)
+
+		_, isUsed := used[entry.id]
+
+		if !isUsed {
+			report.UnreferencedChunks++
+			report.UnreferencedBytes += uint64(entry.size)
+		}
+
+		if entry.location == locationLoose {
+			if !isUsed {
+				loose = append(loose, entry)
+			}
+
+			continue
+		}
+
+		usage, ok := packs[entry.pack]
+		if !ok {
+			usage = &packUsage{}
+			packs[entry.pack] = usage
+		}
+
+		if isUsed {
+			usage.used = append(usage.used, entry)
+		} else {
+			usage.unused = append(usage.unused, entry)
+		}
+	}
+
+	obsolete := make(map[xid.ID]struct{})
+
+	for id, usage := range packs {
+		if len(usage.unused) == 0 {
+			continue
+		}
+
+		obsolete[id] = struct{}{}
+
+		if len(usage.used) == 0 {
+			report.RemovedPacks++
+			continue
+		}
+
+		report.RepackedPacks++
+
+		if dryRun {
+			continue
+		}
+
+		for _, entry := range usage.used {
+			data, err := storage.readPackChunk(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := storage.storeChunk(entry.id, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	report.RemovedLoose = len(loose)
+
+	if dryRun {
+		return report, nil
+	}
+
+	// new packs and the new index must be in place before anything is removed
+	if err := storage.finishPack(); err != nil {
+		return nil, err
+	}
+
+	removed := make(map[chunkID]struct{}, len(loose))
+	for _, entry := range loose {
+		removed[entry.id] = struct{}{}
+	}
+
+	// the index now points repacked chunks to their new packs, including
+	// those already flushed to index files while repacking
+	var keep []indexEntry
+
+	for _, entry := range storage.index.all() {
+		if _, ok := obsolete[entry.pack]; ok && entry.location == locationPack {
+			continue
+		}
+
+		if _, ok := removed[entry.id]; ok && entry.location == locationLoose {
+			continue
+		}
+
+		keep = append(keep, entry)
+	}
+
+	if err := storage.replaceIndex(keep); err != nil {
+		return nil, err
+	}
+
+	for id := range obsolete {
+		if err := storage.backend.Delete(storage.getPackPath(id)); err != nil && !errors.Is(err, backend.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	for _, entry := range loose {
+		if err := storage.backend.Delete(storage.getStoragePath(entry.id.String())); err != nil && !errors.Is(err, backend.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
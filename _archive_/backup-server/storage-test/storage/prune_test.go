@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+// writeTestBlocksShared writes the streams into the same packs and stores a
+// block for each of them.
+func writeTestBlocksShared(t *testing.T, store *Storage, streams ...[]byte) []*Block {
+	t.Helper()
+
+	var blocks []*Block
+
+	for _, data := range streams {
+		var stats writeStats
+
+		blobs, size, checksum, err := store.writeStream(bytes.NewReader(data), nil, &stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block := NewBlock()
+		block.Blobs = blobs
+		block.Size = size
+		block.CheckSum = checksum
+
+		blocks = append(blocks, block)
+	}
+
+	if err := store.flushIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range blocks {
+		if err := store.writeBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return blocks
+}
+
+func TestPruneRepacksAcrossIndexFlushes(t *testing.T) {
+	defer func(entries int) { indexFlushEntries = entries }(indexFlushEntries)
+
+	// repacking flushes the index several times
+	indexFlushEntries = 10
+
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	removed, kept := randomData(1, 2<<20), randomData(2, 2<<20)
+	blocks := writeTestBlocksShared(t, store, removed, kept)
+
+	if err := store.DeleteBlock(blocks[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := store.Prune(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.RepackedPacks == 0 {
+		t.Fatalf("nothing was repacked: %+v", report)
+	}
+
+	if report.TotalChunks-report.UnreferencedChunks <= indexFlushEntries {
+		t.Fatalf("only %d chunks repacked", report.TotalChunks-report.UnreferencedChunks)
+	}
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	if got := restoreTestBlock(t, store, blocks[1], RestoreOptions{}); !bytes.Equal(got, kept) {
+		t.Fatal("restored data differs")
+	}
+
+	check, err := store.Check(CheckOptions{ReadData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(check.Errors) > 0 {
+		t.Fatalf("check errors after prune: %+v", check.Errors)
+	}
+}
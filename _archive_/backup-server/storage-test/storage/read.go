@@ -1,24 +1,62 @@
 package storage
 
-import (
-	"log"
-	"os"
+import "fmt"
 
-	"github.com/klauspost/compress/s2"
-)
+// GetChunk returns the chunk content, which is verified against its ID.
+func (storage *Storage) GetChunk(id string) ([]byte, error) {
+	data, err := storage.readChunkData(id)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.readLimit.wait(len(data))
+
+	return storage.decodeChunk(id, data)
+}
 
-func (storage *Storage) GetChunk(id string) []byte {
-	filePath := storage.getStoragePath(id)
+// decodeChunk opens a stored chunk and verifies its content against id.
+func (storage *Storage) decodeChunk(id string, data []byte) ([]byte, error) {
+	plaintext, err := open(storage.key.Encrypt, data)
+	if err != nil {
+		return nil, corruptedError("read chunk", id, err)
+	}
 
-	data, err := os.ReadFile(filePath)
+	dst, err := storage.comp.decompress(plaintext)
 	if err != nil {
-		log.Fatal(err)
+		return nil, corruptedError("read chunk", id, err)
+	}
+
+	if sum := storage.hash(dst); sum != id {
+		return nil, corruptedError("read chunk", id, fmt.Errorf("content hashes to %s", sum))
+	}
+
+	return dst, nil
+}
+
+func (storage *Storage) lookup(id chunkID) (indexEntry, bool) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	return storage.index.get(id)
+}
+
+// readChunkData returns the stored (encrypted) chunk as found in the pack or
+// loose blob file.
+func (storage *Storage) readChunkData(id string) ([]byte, error) {
+	chunk, err := parseChunkID(id)
+	if err != nil {
+		return nil, notFoundError("read chunk", id, err)
+	}
+
+	if entry, ok := storage.lookup(chunk); ok && entry.location == locationPack {
+		return storage.readPackChunk(entry)
 	}
 
-	dst, err := s2.Decode(nil, data)
+	// loose chunks written before pack files were introduced
+	data, err := storage.backend.Get(storage.getStoragePath(id))
 	if err != nil {
-		log.Fatal(err)
+		return nil, ioError("read chunk", id, err)
 	}
 
-	return dst
+	return data, nil
 }
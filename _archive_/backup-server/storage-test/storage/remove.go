@@ -0,0 +1,17 @@
+package storage
+
+func (storage *Storage) DeleteBlock(id string) error {
+	if err := validateObjectID(id); err != nil {
+		return notFoundError("delete block", id, err)
+	}
+
+	return ioError("delete block", id, storage.backend.Delete(storage.blockPath(id)))
+}
+
+func (storage *Storage) DeleteSnapshot(id string) error {
+	if err := validateObjectID(id); err != nil {
+		return notFoundError("delete snapshot", id, err)
+	}
+
+	return ioError("delete snapshot", id, storage.backend.Delete(storage.snapshotPath(id)))
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	defaultPrefetch = 4
+	// defaultRestoreCache keeps repeated chunks of a restore without cache
+	defaultRestoreCache = 64 << 20
+)
+
+var errSparseWriter = errors.New("sparse restore requires a seekable file")
+
+// RestoreOptions tune how a stream is restored.
+type RestoreOptions struct {
+	// Prefetch is the number of chunks read ahead in parallel.
+	Prefetch int
+	// Cache serves repeated chunks, a cache of defaultRestoreCache bytes is
+	// used when nil.
+	Cache *ChunkCache
+	// Sparse seeks over all-zero chunks instead of writing them, leaving
+	// holes. The writer must be a file written from its start, e.g. an
+	// *os.File.
+	Sparse bool
+	// Progress is called with the length of every restored blob.
+	Progress func(n int)
+}
+
+// sparseWriter is implemented by *os.File.
+type sparseWriter interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+type fetchResult struct {
+	item *cacheItem
+	err  error
+}
+
+// RestoreStream writes the blobs in order to writer. Chunks are read ahead
+// in parallel and the stream checksum is verified on the fly, a mismatch is
+// reported as ErrCorrupted after all data was written.
+func (storage *Storage) RestoreStream(writer io.Writer, blobs []Blob, checksum string, opts RestoreOptions) error {
+	hash := storage.hasher.new()
+
+	if err := storage.streamBlobs(writer, blobs, opts, hash); err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum {
+		return corruptedError("verify stream", "", fmt.Errorf("checksum %s, expected %s", sum, checksum))
+	}
+
+	return nil
+}
+
+// streamBlobs writes the blobs in order to writer and to hash unless nil,
+// reading up to opts.Prefetch chunks ahead.
+func (storage *Storage) streamBlobs(writer io.Writer, blobs []Blob, opts RestoreOptions, hash io.Writer) error {
+	prefetch := opts.Prefetch
+	if prefetch <= 0 {
+		prefetch = defaultPrefetch
+	}
+
+	cache := opts.Cache
+	if cache == nil {
+		cache = NewChunkCache(storage, defaultRestoreCache)
+	}
+
+	var sparse sparseWriter
+
+	if opts.Sparse {
+		var ok bool
+
+		if sparse, ok = writer.(sparseWriter); !ok {
+			return errSparseWriter
+		}
+	}
+
+	writer = storage.writeLimit.Writer(writer)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	queue := make(chan chan fetchResult, prefetch)
+
+	go func() {
+		defer close(queue)
+
+		for _, blob := range blobs {
+			result := make(chan fetchResult, 1)
+
+			select {
+			case queue <- result:
+			case <-done:
+				return
+			}
+
+			go func(blob Blob) {
+				item, err := cache.get(blob.ID)
+				if err == nil && item.length != int(blob.Length) {
+					err = corruptedError("read chunk", blob.ID, fmt.Errorf("chunk has %d bytes, expected %d", item.length, blob.Length))
+				}
+
+				result <- fetchResult{item: item, err: err}
+			}(blob)
+		}
+	}()
+
+	// hole is the length of the zeros skipped since the last write
+	var hole int64
+
+	for result := range queue {
+		fetched := <-result
+		if fetched.err != nil {
+			return fetched.err
+		}
+
+		item := fetched.item
+
+		if hash != nil {
+			if err := writeCacheItem(hash, item); err != nil {
+				return err
+			}
+		}
+
+		if item.zero && sparse != nil {
+			hole += int64(item.length)
+		} else {
+			if hole > 0 {
+				if _, err := sparse.Seek(hole, io.SeekCurrent); err != nil {
+					return err
+				}
+
+				hole = 0
+			}
+
+			if err := writeCacheItem(writer, item); err != nil {
+				return err
+			}
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(item.length)
+		}
+	}
+
+	// a trailing hole is not written, only the file size is set
+	if hole > 0 {
+		end, err := sparse.Seek(hole, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		return sparse.Truncate(end)
+	}
+
+	return nil
+}
+
+func writeCacheItem(writer io.Writer, item *cacheItem) error {
+	if !item.zero {
+		_, err := writer.Write(item.data)
+		return err
+	}
+
+	for left := item.length; left > 0; {
+		n := left
+		if n > len(zeroBlock) {
+			n = len(zeroBlock)
+		}
+
+		if _, err := writer.Write(zeroBlock[:n]); err != nil {
+			return err
+		}
+
+		left -= n
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSparseRestore(t *testing.T) {
+	store := newTestStorage(t, StorageConfig{})
+	defer store.Close()
+
+	var data []byte
+	data = append(data, randomData(1, 100<<10)...)
+	data = append(data, make([]byte, 500<<10)...)
+	data = append(data, randomData(2, 100<<10)...)
+	// a trailing hole must still extend the file
+	data = append(data, make([]byte, 300<<10)...)
+
+	block := writeTestBlock(t, store, data)
+
+	name := filepath.Join(t.TempDir(), "restored")
+
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.RestoreStream(file, block.Blobs, block.CheckSum, RestoreOptions{Sparse: true})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(restored, data) {
+		t.Fatalf("restored %d bytes differ from %d bytes", len(restored), len(data))
+	}
+
+	err = store.RestoreStream(&bytes.Buffer{}, block.Blobs, block.CheckSum, RestoreOptions{Sparse: true})
+	if !errors.Is(err, errSparseWriter) {
+		t.Fatalf("got %v, want errSparseWriter", err)
+	}
+}
+
+func TestRestoreDiskCache(t *testing.T) {
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	// repeated content, so the small memory cache evicts chunks still needed
+	part := randomData(1, 200<<10)
+	data := append(append(append([]byte{}, part...), randomData(2, 200<<10)...), part...)
+
+	block := writeTestBlock(t, store, data)
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	dir := t.TempDir()
+
+	cache := NewChunkCache(store, 64<<10)
+	if err := cache.SetDisk(dir, 64<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := restoreTestBlock(t, store, block, RestoreOptions{Cache: cache}); !bytes.Equal(got, data) {
+		t.Fatal("restored data differs")
+	}
+
+	// every chunk is served from the disk cache now
+	for _, pack := range testPacks(t, path) {
+		if err := os.Remove(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache = NewChunkCache(store, 64<<10)
+	if err := cache.SetDisk(dir, 64<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := restoreTestBlock(t, store, block, RestoreOptions{Cache: cache}); !bytes.Equal(got, data) {
+		t.Fatal("restored data from cache differs")
+	}
+
+	err := store.RestoreStream(&bytes.Buffer{}, block.Blobs, block.CheckSum, RestoreOptions{})
+	if err == nil {
+		t.Fatal("restored without packs and cache")
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type RetentionPolicy struct {
+	Last    int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+type RetentionItem struct {
+	ID        string
+	Type      string
+	Group     string
+	Timestamp int64
+}
+
+type RetentionResult struct {
+	Keep   []RetentionItem
+	Remove []RetentionItem
+}
+
+func (policy RetentionPolicy) Empty() bool {
+	return policy.Last == 0 && policy.Hourly == 0 && policy.Daily == 0 &&
+		policy.Weekly == 0 && policy.Monthly == 0 && policy.Yearly == 0
+}
+
+type retentionRule struct {
+	count  int
+	bucket func(time.Time) string
+	last   string
+}
+
+// ApplyRetention splits items into kept and removed ones. Items are grouped
+// by Group and every group is evaluated on its own; within a group the
+// newest item of each hour/day/week/month/year bucket is kept until the
+// rule's count is exhausted.
+func ApplyRetention(items []RetentionItem, policy RetentionPolicy) RetentionResult {
+	var result RetentionResult
+
+	if policy.Empty() {
+		result.Keep = append(result.Keep, items...)
+		return result
+	}
+
+	groups := make(map[string][]RetentionItem)
+	for _, item := range items {
+		groups[item.Group] = append(groups[item.Group], item)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		group := groups[name]
+
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].Timestamp > group[j].Timestamp
+		})
+
+		rules := []*retentionRule{
+			{count: policy.Last, bucket: nil},
+			{count: policy.Hourly, bucket: func(t time.Time) string { return t.Format("2006-01-02 15") }},
+			{count: policy.Daily, bucket: func(t time.Time) string { return t.Format("2006-01-02") }},
+			{count: policy.Weekly, bucket: func(t time.Time) string {
+				year, week := t.ISOWeek()
+				return fmt.Sprintf("%d-%02d", year, week)
+			}},
+			{count: policy.Monthly, bucket: func(t time.Time) string { return t.Format("2006-01") }},
+			{count: policy.Yearly, bucket: func(t time.Time) string { return t.Format("2006") }},
+		}
+
+		for _, item := range group {
+			keep := false
+			ts := time.Unix(item.Timestamp, 0).UTC()
+
+			for _, rule := range rules {
+				if rule.count == 0 {
+					continue
+				}
+
+				if rule.bucket == nil {
+					rule.count--
+					keep = true
+					continue
+				}
+
+				if bucket := rule.bucket(ts); bucket != rule.last {
+					rule.last = bucket
+					rule.count--
+					keep = true
+				}
+			}
+
+			if keep {
+				result.Keep = append(result.Keep, item)
+			} else {
+				result.Remove = append(result.Remove, item)
+			}
+		}
+	}
+
+	return result
+}
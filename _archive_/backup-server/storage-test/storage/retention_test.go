@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyRetentionGroups(t *testing.T) {
+	start := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	var items []RetentionItem
+
+	// a busy source with hourly backups and a daily one
+	for i := 0; i < 24; i++ {
+		items = append(items, RetentionItem{
+			ID:        "busy" + string(rune('a'+i)),
+			Type:      "block",
+			Group:     "block /var/busy",
+			Timestamp: start.Add(time.Duration(i) * time.Hour).Unix(),
+		})
+	}
+
+	for i := 0; i < 3; i++ {
+		items = append(items, RetentionItem{
+			ID:        "daily" + string(rune('a'+i)),
+			Type:      "block",
+			Group:     "block /var/daily",
+			Timestamp: start.AddDate(0, 0, i-3).Unix(),
+		})
+	}
+
+	result := ApplyRetention(items, RetentionPolicy{Last: 2})
+
+	kept := make(map[string]bool)
+	for _, item := range result.Keep {
+		kept[item.ID] = true
+	}
+
+	for _, id := range []string{"busyx", "busyw", "dailyc", "dailyb"} {
+		if !kept[id] {
+			t.Errorf("%s was removed", id)
+		}
+	}
+
+	if len(result.Keep) != 4 || len(result.Remove) != len(items)-4 {
+		t.Fatalf("kept %d, removed %d", len(result.Keep), len(result.Remove))
+	}
+}
+
+func TestApplyRetentionDaily(t *testing.T) {
+	start := time.Date(2022, 7, 1, 10, 0, 0, 0, time.UTC)
+
+	var items []RetentionItem
+
+	for day := 0; day < 5; day++ {
+		for hour := 0; hour < 3; hour++ {
+			items = append(items, RetentionItem{
+				ID:        string(rune('a'+day)) + string(rune('0'+hour)),
+				Group:     "snapshot",
+				Timestamp: start.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour).Unix(),
+			})
+		}
+	}
+
+	result := ApplyRetention(items, RetentionPolicy{Daily: 3})
+
+	if len(result.Keep) != 3 {
+		t.Fatalf("kept %d items, want 3", len(result.Keep))
+	}
+
+	// the newest item of each of the last three days
+	for _, item := range result.Keep {
+		if item.ID[1] != '2' || item.ID[0] < 'c' {
+			t.Errorf("kept %s", item.ID)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/rs/xid"
+)
+
+type Snapshot struct {
+	ID        string
+	Tree      string
+	Paths     []string
+	Hostname  string
+	Tags      []string
+	Size      uint64
+	Timestamp int64
+}
+
+type SnapshotOptions struct {
+	Hostname string
+	Tags     []string
+	// Reader wraps every file reader, e.g. to report progress.
+	Reader func(io.Reader) io.Reader
+	// Progress is called with the current stats after every chunk.
+	Progress func(WriteStats)
+}
+
+func NewSnapshot() *Snapshot {
+	return &Snapshot{
+		ID:        xid.New().String(),
+		Timestamp: time.Now().UTC().Unix(),
+	}
+}
+
+func (storage *Storage) snapshotPath(id string) string {
+	return path.Join("snapshots", id[0:4], fmt.Sprintf("%s.dat", id))
+}
+
+func (storage *Storage) writeSnapshot(snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	dst, err := seal(storage.key.Encrypt, s2.EncodeBest(nil, data))
+	if err != nil {
+		return err
+	}
+
+	return ioError("write snapshot", snapshot.ID, storage.backend.Put(storage.snapshotPath(snapshot.ID), dst))
+}
+
+func (storage *Storage) GetSnapshot(id string) (snapshot *Snapshot, err error) {
+	if err := validateObjectID(id); err != nil {
+		return nil, notFoundError("read snapshot", id, err)
+	}
+
+	if err := storage.readObject("read snapshot", id, storage.snapshotPath(id), &snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, root string, files map[string][]byte) {
+	t.Helper()
+
+	for name, data := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func backupTestPath(t *testing.T, store *Storage, root string) *Snapshot {
+	t.Helper()
+
+	snapshot, _, err := store.BackupPath(root, SnapshotOptions{Hostname: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return snapshot
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string][]byte{
+		"a.txt":            []byte("a"),
+		"empty":            nil,
+		"docs/large.bin":   randomData(1, 200<<10),
+		"docs/deep/b.txt":  []byte("b"),
+		"static/c.bin":     randomData(2, 70<<10),
+		"static/copy.bin":  randomData(2, 70<<10),
+		"static/other.txt": []byte("other"),
+	}
+
+	writeTestFiles(t, root, files)
+
+	if err := os.Symlink("a.txt", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{Path: path, Create: true})
+
+	first := backupTestPath(t, store, root)
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	snapshot, err := store.GetSnapshot(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshot.Tree != first.Tree || snapshot.Hostname != "test" {
+		t.Fatalf("got snapshot %+v", snapshot)
+	}
+
+	var names []string
+
+	err = store.WalkTree(snapshot.Tree, "/", func(name string, node *Node) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(names)
+
+	want := []string{
+		"/a.txt", "/docs", "/docs/deep", "/docs/deep/b.txt", "/docs/large.bin", "/empty", "/link",
+		"/static", "/static/c.bin", "/static/copy.bin", "/static/other.txt",
+	}
+
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+
+	for name, data := range files {
+		node, err := store.FindNode(snapshot, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+
+		if err := store.RestoreStream(&buf, node.Content, node.CheckSum, RestoreOptions{}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !bytes.Equal(buf.Bytes(), data) || node.Size != uint64(len(data)) {
+			t.Fatalf("%s: restored content differs", name)
+		}
+	}
+
+	link, err := store.FindNode(snapshot, "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if link.Type != NodeTypeSymlink || link.LinkTarget != "a.txt" {
+		t.Fatalf("got link %+v", link)
+	}
+
+	// change, add and remove files outside of static
+	writeTestFiles(t, root, map[string][]byte{
+		"a.txt":    []byte("changed"),
+		"docs/new": []byte("new"),
+	})
+
+	if err := os.Remove(filepath.Join(root, "docs", "deep", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	second := backupTestPath(t, store, root)
+
+	oldStatic, err := store.FindNode(first, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newStatic, err := store.FindNode(second, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if oldStatic.Subtree != newStatic.Subtree {
+		t.Fatal("unchanged directory got a new tree")
+	}
+
+	changes, err := store.DiffSnapshots(first.ID, second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantChanges := map[string]string{
+		"/a.txt":           ChangeModified,
+		"/docs/new":        ChangeAdded,
+		"/docs/deep/b.txt": ChangeRemoved,
+	}
+
+	for _, change := range changes {
+		// directory changes, e.g. of their modification time, are not checked
+		if change.Path[len(change.Path)-1] == '/' {
+			continue
+		}
+
+		if wantChanges[change.Path] != change.Change {
+			t.Fatalf("unexpected change %+v", change)
+		}
+
+		delete(wantChanges, change.Path)
+	}
+
+	if len(wantChanges) > 0 {
+		t.Fatalf("missing changes %v", wantChanges)
+	}
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"errors"
+	"math/bits"
+
+	"github.com/vitalvas/backup-server/storage-test/backend"
+)
+
+type HistogramBucket struct {
+	// MaxSize is the exclusive upper bound of the chunk sizes in the bucket.
+	MaxSize uint64 `json:"max_size"`
+	Chunks  int    `json:"chunks"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+type RepositoryStats struct {
+	Blocks    int `json:"blocks"`
+	Snapshots int `json:"snapshots"`
+	// TotalChunks and TotalBytes count every chunk reference of all
+	// blocks and files, before deduplication.
+	TotalChunks int    `json:"total_chunks"`
+	TotalBytes  uint64 `json:"total_bytes"`
+	// UniqueChunks and UniqueBytes count distinct chunks by their
+	// uncompressed size, StoredBytes is their encoded size in the repository.
+	// Loose chunks missing from the index are included.
+	UniqueChunks int    `json:"unique_chunks"`
+	UniqueBytes  uint64 `json:"unique_bytes"`
+	StoredBytes  uint64 `json:"stored_bytes"`
+	// MissingChunks and MissingBytes count the distinct chunks that are not
+	// stored at all, they are part of the unique figures only.
+	MissingChunks int               `json:"missing_chunks"`
+	MissingBytes  uint64            `json:"missing_bytes"`
+	Histogram     []HistogramBucket `json:"histogram"`
+}
+
+// DedupRatio is the referenced data size relative to the unique data size.
+func (stats *RepositoryStats) DedupRatio() float64 {
+	if stats.UniqueBytes == 0 {
+		return 0
+	}
+
+	return float64(stats.TotalBytes) / float64(stats.UniqueBytes)
+}
+
+// CompressionRatio is the size of the stored unique data relative to its
+// stored size.
+func (stats *RepositoryStats) CompressionRatio() float64 {
+	if stats.StoredBytes == 0 {
+		return 0
+	}
+
+	return float64(stats.UniqueBytes-stats.MissingBytes) / float64(stats.StoredBytes)
+}
+
+type statsCollector struct {
+	storage *Storage
+	stats   *RepositoryStats
+	chunks  map[string]struct{}
+	buckets map[int]*HistogramBucket
+}
+
+func (collector *statsCollector) addStream(blobs []Blob) error {
+	for _, blob := range blobs {
+		collector.stats.TotalChunks++
+		collector.stats.TotalBytes += uint64(blob.Length)
+
+		if _, ok := collector.chunks[blob.ID]; ok {
+			continue
+		}
+
+		collector.chunks[blob.ID] = struct{}{}
+
+		collector.stats.UniqueChunks++
+		collector.stats.UniqueBytes += uint64(blob.Length)
+
+		size, err := collector.storage.storedSize(blob.ID)
+
+		switch {
+		case err == nil:
+			collector.stats.StoredBytes += size
+
+		case errors.Is(err, ErrNotFound):
+			collector.stats.MissingChunks++
+			collector.stats.MissingBytes += uint64(blob.Length)
+
+		default:
+			return err
+		}
+
+		// power of two buckets
+		bucketBits := bits.Len64(uint64(blob.Length))
+
+		bucket, ok := collector.buckets[bucketBits]
+		if !ok {
+			bucket = &HistogramBucket{MaxSize: 1 << bucketBits}
+			collector.buckets[bucketBits] = bucket
+		}
+
+		bucket.Chunks++
+		bucket.Bytes += uint64(blob.Length)
+	}
+
+	return nil
+}
+
+// storedSize returns the encoded size of a chunk, loose chunks missing from
+// the index are looked up in the backend.
+func (storage *Storage) storedSize(id string) (uint64, error) {
+	chunk, err := parseChunkID(id)
+	if err != nil {
+		return 0, notFoundError("stat chunk", id, err)
+	}
+
+	if entry, ok := storage.lookup(chunk); ok {
+		return uint64(entry.size), nil
+	}
+
+	info, err := storage.backend.Stat(storage.getStoragePath(id))
+	if errors.Is(err, backend.ErrNotExist) {
+		return 0, notFoundError("stat chunk", id, err)
+	}
+
+	if err != nil {
+		return 0, ioError("stat chunk", id, err)
+	}
+
+	return uint64(info.Size), nil
+}
+
+// Stats reports the size distribution of the data chunks referenced by all
+// blocks and snapshots together with dedup and compression figures. Tree
+// chunks are not included.
+func (storage *Storage) Stats() (*RepositoryStats, error) {
+	collector := &statsCollector{
+		storage: storage,
+		stats:   &RepositoryStats{Histogram: []HistogramBucket{}},
+		chunks:  make(map[string]struct{}),
+		buckets: make(map[int]*HistogramBucket),
+	}
+
+	blocks, err := storage.ListBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range blocks {
+		block, err := storage.GetBlock(id)
+		if err != nil {
+			return nil, err
+		}
+
+		collector.stats.Blocks++
+
+		if err := collector.addStream(block.Blobs); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshots, err := storage.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range snapshots {
+		snapshot, err := storage.GetSnapshot(id)
+		if err != nil {
+			return nil, err
+		}
+
+		collector.stats.Snapshots++
+
+		err = storage.WalkTree(snapshot.Tree, "/", func(_ string, node *Node) error {
+			return collector.addStream(node.Content)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for bucketBits := 0; bucketBits <= 64 && len(collector.stats.Histogram) < len(collector.buckets); bucketBits++ {
+		if bucket, ok := collector.buckets[bucketBits]; ok {
+			collector.stats.Histogram = append(collector.stats.Histogram, *bucket)
+		}
+	}
+
+	return collector.stats, nil
+}
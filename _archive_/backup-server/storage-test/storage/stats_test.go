@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStatsStoredBytes(t *testing.T) {
+	store := newTestStorage(t, StorageConfig{})
+	defer store.Close()
+
+	data := randomData(1, 256<<10)
+
+	writeTestBlock(t, store, append(append([]byte{}, data...), data...))
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second copy is mostly deduplicated
+	if stats.TotalBytes != uint64(2*len(data)) || stats.UniqueBytes >= uint64(3*len(data)/2) {
+		t.Fatalf("total %d, unique %d", stats.TotalBytes, stats.UniqueBytes)
+	}
+
+	if stats.StoredBytes < stats.UniqueBytes || stats.MissingChunks != 0 {
+		t.Fatalf("stored %d of %d, missing %d", stats.StoredBytes, stats.UniqueBytes, stats.MissingChunks)
+	}
+
+	// a loose chunk of an older version, not listed in the index, and a
+	// chunk that is not stored at all
+	loose := store.hash([]byte("loose"))
+	missing := store.hash([]byte("missing"))
+
+	if err := store.backend.Put(store.getStoragePath(loose), bytes.Repeat([]byte{1}, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewBlock()
+	other.Blobs = []Blob{
+		{ID: loose, Length: 5},
+		{ID: missing, Offset: 5, Length: 7},
+	}
+
+	if err := store.writeBlock(other); err != nil {
+		t.Fatal(err)
+	}
+
+	withLoose, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withLoose.StoredBytes != stats.StoredBytes+100 {
+		t.Fatalf("stored %d, want %d", withLoose.StoredBytes, stats.StoredBytes+100)
+	}
+
+	if withLoose.MissingChunks != 1 || withLoose.MissingBytes != 7 {
+		t.Fatalf("missing %d chunks, %d bytes", withLoose.MissingChunks, withLoose.MissingBytes)
+	}
+
+	if withLoose.Blocks != 2 {
+		t.Fatalf("got %d blocks", withLoose.Blocks)
+	}
+}
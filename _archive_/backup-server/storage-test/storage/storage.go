@@ -1,42 +1,238 @@
 package storage
 
 import (
+	"errors"
 	"log"
+	"runtime"
+	"sync"
 
-	"github.com/restic/chunker"
-	"github.com/zeebo/blake3"
+	"github.com/vitalvas/backup-server/storage-test/backend"
 )
 
 type Storage struct {
-	discard bool
-	path    string
-	pol     chunker.Pol
-	chunks  map[string]bool
+	discard   bool
+	backend   backend.Backend
+	config    *Config
+	comp      *compressor
+	hasher    *chunkHasher
+	key       *masterKey
+	workers   int
+	uploaders int
+	maxMemory int64
+
+	readLimit  *Throttle
+	writeLimit *Throttle
+
+	mu       sync.Mutex
+	index    *index
+	pack     *pack
+	inflight map[chunkID]struct{}
+
+	repoLock *repoLock
 }
 
 type StorageConfig struct {
 	Discard bool
-	Path    string
+	// Path is a local directory or a repository url, see backend.Open.
+	Path     string
+	Password string
+	// Token authenticates to a backup server, see backend.Open.
+	Token string
+	// Workers is the number of hash/compress workers, one disables the
+	// concurrent pipeline. Defaults to the number of CPUs.
+	Workers int
+	// Uploaders is the number of concurrent pack writers.
+	Uploaders int
+	// MaxMemory limits the chunk data held by the pipeline.
+	MaxMemory int64
+	// Chunker and Compression are used when the repository is created,
+	// zero values are replaced by defaults.
+	Chunker     ChunkerParams
+	Compression CompressionParams
+	// ChunkHash is the chunk ID hash of a new repository, HighwayHash by
+	// default.
+	ChunkHash string
+	// Create initializes a new repository, it fails for existing
+	// repositories and locations holding other objects. Without Create a
+	// missing repository is an error.
+	Create bool
+	// Lock is taken while the repository is open, it is ignored in
+	// discard mode.
+	Lock LockMode
+	// Source makes a new repository share the chunk ID key, hash and chunker
+	// parameters of an existing one, so chunks can be copied from it.
+	Source *Storage
+	// ReadLimit throttles the data read: backup input and chunks read from
+	// the repository. WriteLimit throttles stored chunks and restored
+	// output. Their operation limits count backend requests, puts and
+	// deletes for WriteLimit and all others for ReadLimit. Nil is unlimited.
+	ReadLimit  *Throttle
+	WriteLimit *Throttle
 }
 
-func New(conf StorageConfig) *Storage {
+func New(conf StorageConfig) (*Storage, error) {
 	storage := &Storage{
-		discard: conf.Discard,
-		path:    conf.Path,
-		chunks:  make(map[string]bool),
+		discard:    conf.Discard,
+		workers:    conf.Workers,
+		uploaders:  conf.Uploaders,
+		maxMemory:  conf.MaxMemory,
+		readLimit:  conf.ReadLimit,
+		writeLimit: conf.WriteLimit,
+		index:      newIndex(),
+		inflight:   make(map[chunkID]struct{}),
+	}
+
+	if storage.workers <= 0 {
+		storage.workers = runtime.NumCPU()
+	}
+
+	if storage.uploaders <= 0 {
+		storage.uploaders = defaultUploaders
 	}
 
-	if !storage.discard {
-		storage.InitStorageTree()
+	if storage.maxMemory <= 0 {
+		storage.maxMemory = defaultMaxMemory
 	}
 
 	var err error
 
-	chunkerPolHash := blake3.NewDeriveKey("backup-server/storage-test")
-	storage.pol, err = chunker.DerivePolynomial(chunkerPolHash.Digest())
+	if storage.discard {
+		storage.key, err = newMasterKey()
+		if err != nil {
+			return nil, err
+		}
+
+		if conf.Chunker.Polynomial == 0 {
+			if conf.Chunker.Polynomial, err = derivedPolynomial(); err != nil {
+				return nil, err
+			}
+		}
+
+		if storage.config, err = newConfig(conf.Chunker, conf.Compression, conf.ChunkHash); err != nil {
+			return nil, err
+		}
+
+	} else {
+		storage.backend, err = backend.Open(conf.Path, conf.Token)
+		if err != nil {
+			return nil, ioError("open repository", conf.Path, err)
+		}
+
+		storage.backend = newThrottledBackend(storage.backend, conf.ReadLimit, conf.WriteLimit)
+
+		_, statErr := storage.backend.Stat(keyFileName)
+
+		switch {
+		case errors.Is(statErr, backend.ErrNotExist) && !conf.Create:
+			plain, err := isPlainLayout(storage.backend)
+			if err != nil {
+				return nil, ioError("open repository", "", err)
+			}
+
+			if plain {
+				return nil, ErrPlainRepository
+			}
+
+			return nil, ErrNotInitialized
+
+		case errors.Is(statErr, backend.ErrNotExist):
+			if err := storage.checkEmpty(); err != nil {
+				return nil, err
+			}
+
+			log.Println("init repository")
+
+			var chunkIDKey []byte
+
+			if conf.Source != nil {
+				conf.Chunker = conf.Source.config.Chunker
+				conf.ChunkHash = conf.Source.config.ChunkHash
+				chunkIDKey = conf.Source.key.ChunkID
+			}
+
+			if storage.config, err = newConfig(conf.Chunker, conf.Compression, conf.ChunkHash); err != nil {
+				return nil, err
+			}
+
+			if storage.key, err = storage.createKey(conf.Password, chunkIDKey); err != nil {
+				return nil, ioError("create repository key", "", err)
+			}
+
+			if err := storage.writeConfig(); err != nil {
+				return nil, err
+			}
+
+		case statErr != nil:
+			return nil, ioError("open repository key", "", statErr)
+
+		case conf.Create:
+			return nil, ErrExists
+
+		default:
+			if storage.key, err = storage.loadKey(conf.Password); err != nil {
+				return nil, err
+			}
+
+			if err := storage.loadConfig(); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := storage.lock(conf.Lock); err != nil {
+			return nil, err
+		}
+
+		if err := storage.loadIndex(); err != nil {
+			storage.unlock()
+			return nil, err
+		}
+	}
+
+	if storage.hasher, err = newChunkHasher(storage.config.ChunkHash, storage.key.ChunkID); err != nil {
+		storage.unlock()
+		return nil, err
+	}
+
+	if storage.comp, err = newCompressor(storage.config); err != nil {
+		storage.unlock()
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// checkEmpty refuses to create a repository next to other objects.
+func (storage *Storage) checkEmpty() error {
+	files, err := storage.backend.List("")
+	if err != nil {
+		return ioError("open repository", "", err)
+	}
+
+	if len(files) > 0 {
+		return ErrNotEmpty
+	}
+
+	return nil
+}
+
+// Close flushes all pending index entries, releases the repository lock
+// and closes the backend.
+func (storage *Storage) Close() error {
+	defer storage.comp.close()
+
+	if storage.discard {
+		return nil
+	}
+
+	err := storage.flushIndex()
+
+	if unlockErr := storage.unlock(); err == nil {
+		err = unlockErr
+	}
+
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	return storage
+	return storage.backend.Close()
 }
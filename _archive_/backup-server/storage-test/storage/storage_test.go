@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+const testPassword = "test"
+
+// testChunker gives small chunks, so tests cover many chunks and packs with
+// little data.
+var testChunker = ChunkerParams{
+	MinSize: 4 << 10,
+	AvgSize: 16 << 10,
+	MaxSize: 64 << 10,
+}
+
+func newTestStorage(t *testing.T, conf StorageConfig) *Storage {
+	t.Helper()
+
+	if len(conf.Path) == 0 {
+		conf.Path = t.TempDir()
+		conf.Create = true
+	}
+
+	if len(conf.Password) == 0 {
+		conf.Password = testPassword
+	}
+
+	if conf.Chunker.AvgSize == 0 {
+		conf.Chunker = testChunker
+	}
+
+	store, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// reopenTestStorage closes store and opens its repository again.
+func reopenTestStorage(t *testing.T, store *Storage, path string) *Storage {
+	t.Helper()
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return newTestStorage(t, StorageConfig{Path: path})
+}
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+
+	return data
+}
+
+func writeTestBlock(t *testing.T, store *Storage, data []byte) *Block {
+	t.Helper()
+
+	stats, err := store.Writer(bytes.NewReader(data), WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := store.GetBlock(stats.BlockID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+func restoreTestBlock(t *testing.T, store *Storage, block *Block, opts RestoreOptions) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	if err := store.RestoreStream(&buf, block.Blobs, block.CheckSum, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+var testCompressible = bytes.Repeat([]byte("compressible chunk content "), 20000)
+
+// testStoredCodecs returns the codec header bytes of the stored chunks of
+// block, in blob order.
+func testStoredCodecs(t *testing.T, store *Storage, block *Block) []byte {
+	t.Helper()
+
+	var codecs []byte
+
+	for _, blob := range block.Blobs {
+		data, err := store.readChunkData(blob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plaintext, err := open(store.key.Encrypt, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		codecs = append(codecs, plaintext[0])
+	}
+
+	return codecs
+}
+
+func TestCompressionCodecs(t *testing.T) {
+	tests := []struct {
+		name   string
+		params CompressionParams
+		codec  byte
+	}{
+		{"s2", CompressionParams{Codec: CompressionS2}, codecS2},
+		{"zstd", CompressionParams{Codec: CompressionZstd}, codecZstd},
+		{"zstd level 1", CompressionParams{Codec: CompressionZstd, Level: 1}, codecZstd},
+		{"zstd level 19", CompressionParams{Codec: CompressionZstd, Level: 19}, codecZstd},
+		{"none", CompressionParams{Codec: CompressionNone}, codecNone},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := t.TempDir()
+			store := newTestStorage(t, StorageConfig{Path: path, Create: true, Compression: test.params})
+
+			block := writeTestBlock(t, store, testCompressible)
+
+			store = reopenTestStorage(t, store, path)
+			defer store.Close()
+
+			if params := store.Config().Compression; params != test.params {
+				t.Fatalf("repository uses %+v", params)
+			}
+
+			for i, codec := range testStoredCodecs(t, store, block) {
+				if codec != test.codec {
+					t.Fatalf("chunk %d has codec %d, want %d", i, codec, test.codec)
+				}
+			}
+
+			if got := restoreTestBlock(t, store, block, RestoreOptions{}); !bytes.Equal(got, testCompressible) {
+				t.Fatal("restored data differs")
+			}
+		})
+	}
+}
+
+func TestInvalidCompression(t *testing.T) {
+	for _, params := range []CompressionParams{
+		{Codec: "gzip"},
+		{Codec: CompressionZstd, Level: 23},
+		{Codec: CompressionZstd, Level: -1},
+		{Codec: CompressionS2, Level: 3},
+	} {
+		if _, err := New(StorageConfig{Path: t.TempDir(), Password: testPassword, Create: true, Compression: params}); err == nil {
+			t.Fatalf("created a repository with %+v", params)
+		}
+	}
+}
+
+func TestZstdLevels(t *testing.T) {
+	data := append(append([]byte{}, testCompressible...), randomData(1, 64<<10)...)
+
+	var sizes []int
+
+	for _, level := range []int{1, 19} {
+		comp, err := newCompressor(&Config{
+			Version:     configVersion,
+			Compression: CompressionParams{Codec: CompressionZstd, Level: level},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer comp.close()
+
+		compressed := comp.compress(data)
+
+		decompressed, err := comp.decompress(compressed)
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Fatalf("level %d: round trip failed: %v", level, err)
+		}
+
+		sizes = append(sizes, len(compressed))
+	}
+
+	if sizes[1] > sizes[0] {
+		t.Fatalf("level 19 gives %d bytes, level 1 %d", sizes[1], sizes[0])
+	}
+}
+
+func TestAdaptiveCompression(t *testing.T) {
+	for _, codec := range []string{CompressionS2, CompressionZstd} {
+		comp, err := newCompressor(&Config{
+			Version:     configVersion,
+			Compression: CompressionParams{Codec: codec, Adaptive: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer comp.close()
+
+		// incompressible data is stored as is
+		random := randomData(1, 100<<10)
+
+		if compressed := comp.compress(random); compressed[0] != codecNone || len(compressed) != len(random)+1 {
+			t.Fatalf("%s: random data stored with codec %d in %d bytes", codec, compressed[0], len(compressed))
+		}
+
+		if compressed := comp.compress(testCompressible); compressed[0] != comp.codec || len(compressed) >= len(testCompressible) {
+			t.Fatalf("%s: compressible data stored with codec %d in %d bytes", codec, compressed[0], len(compressed))
+		}
+	}
+}
+
+func TestMixedCodecs(t *testing.T) {
+	path := t.TempDir()
+	store := newTestStorage(t, StorageConfig{
+		Path:        path,
+		Create:      true,
+		Compression: CompressionParams{Codec: CompressionS2, Adaptive: true},
+	})
+
+	// compressible and random chunks in one block
+	mixed := append(append([]byte{}, testCompressible...), randomData(1, 200<<10)...)
+
+	first := writeTestBlock(t, store, mixed)
+
+	// a repository written with other settings before, e.g. copied chunks
+	conf := *store.config
+	conf.Compression = CompressionParams{Codec: CompressionZstd}
+
+	comp, err := newCompressor(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.comp.close()
+	store.comp = comp
+
+	second := writeTestBlock(t, store, randomData(2, 100<<10))
+
+	store = reopenTestStorage(t, store, path)
+	defer store.Close()
+
+	codecs := make(map[byte]bool)
+
+	for _, block := range []*Block{first, second} {
+		for _, codec := range testStoredCodecs(t, store, block) {
+			codecs[codec] = true
+		}
+	}
+
+	if !codecs[codecS2] || !codecs[codecNone] || !codecs[codecZstd] {
+		t.Fatalf("got codecs %v, want s2, zstd and none", codecs)
+	}
+
+	if got := restoreTestBlock(t, store, first, RestoreOptions{}); !bytes.Equal(got, mixed) {
+		t.Fatal("first block differs")
+	}
+
+	if got := restoreTestBlock(t, store, second, RestoreOptions{}); !bytes.Equal(got, randomData(2, 100<<10)) {
+		t.Fatal("second block differs")
+	}
+}
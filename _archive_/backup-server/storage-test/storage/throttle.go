@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/vitalvas/backup-server/storage-test/backend"
+	"golang.org/x/time/rate"
+)
+
+const throttleBursts = 10
+
+// Throttle limits the bytes and operations per second of a data path, a
+// zero limit is unlimited. Every backend request is one operation. Limits
+// can be changed while it is in use. A nil Throttle does not limit anything.
+type Throttle struct {
+	bytes *rate.Limiter
+	ops   *rate.Limiter
+}
+
+func NewThrottle(bytesPerSec, opsPerSec int64) *Throttle {
+	throttle := &Throttle{
+		bytes: rate.NewLimiter(rate.Inf, 1),
+		ops:   rate.NewLimiter(rate.Inf, 1),
+	}
+
+	throttle.SetLimits(bytesPerSec, opsPerSec)
+
+	return throttle
+}
+
+// SetLimits replaces both limits, waiting callers pick them up with their
+// next reservation.
+func (throttle *Throttle) SetLimits(bytesPerSec, opsPerSec int64) {
+	setLimit(throttle.bytes, bytesPerSec)
+	setLimit(throttle.ops, opsPerSec)
+}
+
+// Limits returns the current limits, zero means unlimited.
+func (throttle *Throttle) Limits() (bytesPerSec, opsPerSec int64) {
+	if throttle == nil {
+		return 0, 0
+	}
+
+	return getLimit(throttle.bytes), getLimit(throttle.ops)
+}
+
+func setLimit(limiter *rate.Limiter, perSec int64) {
+	if perSec <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+
+	// the burst allows a tenth of a second worth of tokens, larger requests
+	// are split by wait
+	burst := perSec / throttleBursts
+	if burst < 1 {
+		burst = 1
+	}
+
+	limiter.SetBurst(int(burst))
+	limiter.SetLimit(rate.Limit(perSec))
+}
+
+func getLimit(limiter *rate.Limiter) int64 {
+	if limit := limiter.Limit(); limit != rate.Inf {
+		return int64(limit)
+	}
+
+	return 0
+}
+
+// waitOp blocks until one more operation is allowed.
+func (throttle *Throttle) waitOp() {
+	if throttle == nil {
+		return
+	}
+
+	throttle.ops.Wait(context.Background())
+}
+
+// wait blocks until size more bytes are allowed.
+func (throttle *Throttle) wait(size int) {
+	if throttle == nil {
+		return
+	}
+
+	ctx := context.Background()
+
+	for size > 0 {
+		n := size
+		if burst := throttle.bytes.Burst(); n > burst {
+			n = burst
+		}
+
+		// fails only when the burst shrank concurrently, retry with the
+		// new one
+		if err := throttle.bytes.WaitN(ctx, n); err != nil {
+			continue
+		}
+
+		size -= n
+	}
+}
+
+// Reader limits the bytes read from reader.
+func (throttle *Throttle) Reader(reader io.Reader) io.Reader {
+	if throttle == nil {
+		return reader
+	}
+
+	return &throttledReader{reader: reader, throttle: throttle}
+}
+
+// Writer limits the bytes written to writer.
+func (throttle *Throttle) Writer(writer io.Writer) io.Writer {
+	if throttle == nil {
+		return writer
+	}
+
+	return &throttledWriter{writer: writer, throttle: throttle}
+}
+
+type throttledReader struct {
+	reader   io.Reader
+	throttle *Throttle
+}
+
+func (reader *throttledReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+
+	reader.throttle.wait(n)
+
+	return n, err
+}
+
+type throttledWriter struct {
+	writer   io.Writer
+	throttle *Throttle
+}
+
+func (writer *throttledWriter) Write(p []byte) (int, error) {
+	writer.throttle.wait(len(p))
+
+	return writer.writer.Write(p)
+}
+
+// Throttles returns the read and write throttles of the repository.
+func (storage *Storage) Throttles() (read, write *Throttle) {
+	return storage.readLimit, storage.writeLimit
+}
+
+// throttledBackend charges every request as one operation, writes and
+// deletes to the write throttle and all other requests to the read one.
+type throttledBackend struct {
+	backend.Backend
+
+	read  *Throttle
+	write *Throttle
+}
+
+func newThrottledBackend(store backend.Backend, read, write *Throttle) backend.Backend {
+	if read == nil && write == nil {
+		return store
+	}
+
+	return &throttledBackend{Backend: store, read: read, write: write}
+}
+
+func (store *throttledBackend) Put(name string, data []byte) error {
+	store.write.waitOp()
+	return store.Backend.Put(name, data)
+}
+
+func (store *throttledBackend) Create(name string, data []byte) error {
+	store.write.waitOp()
+	return store.Backend.Create(name, data)
+}
+
+func (store *throttledBackend) Get(name string) ([]byte, error) {
+	store.read.waitOp()
+	return store.Backend.Get(name)
+}
+
+func (store *throttledBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	store.read.waitOp()
+	return store.Backend.GetRange(name, offset, length)
+}
+
+func (store *throttledBackend) Stat(name string) (backend.FileInfo, error) {
+	store.read.waitOp()
+	return store.Backend.Stat(name)
+}
+
+func (store *throttledBackend) List(prefix string) ([]backend.FileInfo, error) {
+	store.read.waitOp()
+	return store.Backend.List(prefix)
+}
+
+func (store *throttledBackend) Delete(name string) error {
+	store.write.waitOp()
+	return store.Backend.Delete(name)
+}
+
+// Exists keeps batch checks of the wrapped backend a single operation.
+func (store *throttledBackend) Exists(names []string) (map[string]bool, error) {
+	if exister, ok := store.Backend.(backend.Exister); ok {
+		store.read.waitOp()
+		return exister.Exists(names)
+	}
+
+	// hide this method, so every name is a throttled Stat
+	return backend.Exists(struct{ backend.Backend }{store}, names)
+}
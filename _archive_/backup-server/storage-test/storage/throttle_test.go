@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/vitalvas/backup-server/storage-test/backend"
+)
+
+func TestThrottleChargesBackendRequests(t *testing.T) {
+	read := NewThrottle(0, 100)
+	write := NewThrottle(0, 100)
+
+	store := newThrottledBackend(backend.NewLocal(t.TempDir()), read, write)
+
+	if err := store.Put("object", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+
+	// the burst allows 10 requests, the others wait 10ms each
+	for i := 0; i < 60; i++ {
+		if _, err := store.Stat("object"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(started); elapsed < 400*time.Millisecond {
+		t.Fatalf("60 requests took %s", elapsed)
+	}
+}
+
+func TestThrottleReaderIgnoresOps(t *testing.T) {
+	throttle := NewThrottle(0, 1)
+
+	reader := throttle.Reader(iotest.OneByteReader(bytes.NewReader(make([]byte, 1000))))
+
+	started := time.Now()
+
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("1000 reads took %s", elapsed)
+	}
+}
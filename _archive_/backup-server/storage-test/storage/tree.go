@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	NodeTypeFile    = "file"
+	NodeTypeDir     = "dir"
+	NodeTypeSymlink = "symlink"
+	NodeTypeOther   = "other"
+)
+
+type Tree struct {
+	Nodes []Node
+}
+
+type Node struct {
+	Name       string
+	Type       string
+	Mode       uint32
+	UID        uint32
+	GID        uint32
+	ModTime    int64
+	Size       uint64
+	LinkTarget string            `json:",omitempty"`
+	Xattrs     map[string][]byte `json:",omitempty"`
+	Subtree    string            `json:",omitempty"`
+	Content    []Blob            `json:",omitempty"`
+	CheckSum   string            `json:",omitempty"`
+}
+
+// writeTree stores the tree as a regular chunk, so identical directories
+// are deduplicated like file content.
+func (storage *Storage) writeTree(tree *Tree, stats *writeStats) (string, error) {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return "", err
+	}
+
+	isWrited, id, stored, err := storage.writeChunk(data)
+	if err != nil {
+		return "", err
+	}
+
+	if isWrited {
+		stats.addMetadata(uint64(len(data)), stored)
+	}
+
+	return id, nil
+}
+
+func (storage *Storage) GetTree(id string) (tree *Tree, err error) {
+	data, err := storage.GetChunk(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, corruptedError("read tree", id, err)
+	}
+
+	return tree, nil
+}
+
+// FindNode resolves a slash separated path inside the snapshot tree.
+func (storage *Storage) FindNode(snapshot *Snapshot, name string) (*Node, error) {
+	node := &Node{
+		Name:    "/",
+		Type:    NodeTypeDir,
+		Subtree: snapshot.Tree,
+	}
+
+	for _, part := range strings.Split(strings.Trim(name, "/"), "/") {
+		if len(part) == 0 {
+			continue
+		}
+
+		if node.Type != NodeTypeDir {
+			return nil, fmt.Errorf("%s: not a directory", node.Name)
+		}
+
+		tree, err := storage.GetTree(node.Subtree)
+		if err != nil {
+			return nil, err
+		}
+
+		var found *Node
+
+		for _, child := range tree.Nodes {
+			if child.Name == part {
+				child := child
+				found = &child
+				break
+			}
+		}
+
+		if found == nil {
+			return nil, notFoundError("find", name, errors.New("no such file or directory"))
+		}
+
+		node = found
+	}
+
+	return node, nil
+}
+
+// WalkTree calls fn for every node below the tree in depth-first order.
+func (storage *Storage) WalkTree(id string, prefix string, fn func(name string, node *Node) error) error {
+	tree, err := storage.GetTree(id)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range tree.Nodes {
+		node := node
+		name := path.Join(prefix, node.Name)
+
+		if err := fn(name, &node); err != nil {
+			return err
+		}
+
+		if node.Type == NodeTypeDir {
+			if err := storage.WalkTree(node.Subtree, name, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
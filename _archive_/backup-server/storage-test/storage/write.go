@@ -1,58 +1,69 @@
 package storage
 
 import (
-	"encoding/hex"
 	"fmt"
-	"log"
-	"os"
 	"path"
-
-	"github.com/klauspost/compress/s2"
-	"github.com/minio/highwayhash"
 )
 
-var highwayhashKey = []byte{
-	0xbc, 0x81, 0xd3, 0x01, 0x58, 0x91, 0x4b, 0xb9,
-	0x2b, 0x44, 0x8b, 0x32, 0xc9, 0x35, 0xea, 0xd7,
-	0x52, 0x33, 0x72, 0x7c, 0x20, 0xe1, 0xc1, 0x4f,
-	0xdf, 0xbe, 0xba, 0x04, 0x6b, 0x0e, 0x89, 0x48,
-}
+// writeChunk stores the chunk unless it is known, it returns whether the
+// chunk was new, its ID and the stored size.
+func (storage *Storage) writeChunk(data []byte) (bool, string, int, error) {
+	checksum := storage.hash(data)
 
-func (storage *Storage) writeChunk(data []byte) (bool, string) {
-	checksum := hash(data)
+	id, err := parseChunkID(checksum)
+	if err != nil {
+		return false, "", 0, err
+	}
 
-	if _, ok := storage.chunks[checksum]; ok {
-		return false, checksum
+	if !storage.claimChunk(id) {
+		return false, checksum, 0, nil
 	}
 
 	if storage.discard {
-		storage.chunks[checksum] = true
-		return true, checksum
+		return true, checksum, 0, nil
 	}
 
-	filePath := storage.getStoragePath(checksum)
+	sealed, err := storage.encodeChunk(data)
+	if err != nil {
+		return false, "", 0, err
+	}
 
-	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
-		storage.chunks[checksum] = true
-		return false, checksum
+	if err := storage.storeChunk(id, sealed); err != nil {
+		return false, "", 0, err
 	}
 
-	dst := s2.Encode(nil, data)
+	return true, checksum, len(sealed), nil
+}
+
+// claimChunk reports whether the chunk still has to be stored. Once claimed
+// the chunk is treated as known until its pack is uploaded, so concurrent
+// writers store every chunk only once.
+func (storage *Storage) claimChunk(id chunkID) bool {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
 
-	if err := os.WriteFile(filePath, dst, 0640); err != nil {
-		log.Fatal(err)
+	if _, ok := storage.inflight[id]; ok || storage.index.has(id) {
+		return false
 	}
 
-	storage.chunks[checksum] = true
+	if storage.discard {
+		storage.index.add(indexEntry{id: id})
+		return true
+	}
+
+	storage.inflight[id] = struct{}{}
+
+	return true
+}
 
-	return true, checksum
+func (storage *Storage) encodeChunk(data []byte) ([]byte, error) {
+	return seal(storage.key.Encrypt, storage.comp.compress(data))
 }
 
-func hash(data []byte) string {
-	hashData := highwayhash.Sum(data, highwayhashKey)
-	return hex.EncodeToString(hashData[:])
+func (storage *Storage) hash(data []byte) string {
+	return storage.hasher.sum(data).String()
 }
 
 func (storage *Storage) getStoragePath(checksum string) string {
-	return path.Join(storage.path, ".chunks", checksum[0:2], checksum[2:4], fmt.Sprintf("%s.blob", checksum))
+	return path.Join(".chunks", checksum[0:2], checksum[2:4], fmt.Sprintf("%s.blob", checksum))
 }
@@ -2,35 +2,300 @@ package storage
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"time"
 
 	"github.com/dustin/go-humanize"
-	"github.com/minio/highwayhash"
-	"github.com/restic/chunker"
 )
 
-const (
-	chunkerMinSize = 256 * (1 << 10) // 256 KB
-)
+// WriteStats describes a running or finished backup. New bytes are chunk
+// data not yet in the repository, stored bytes their compressed and
+// encrypted size.
+type WriteStats struct {
+	BlockID     string        `json:"block_id,omitempty"`
+	SnapshotID  string        `json:"snapshot_id,omitempty"`
+	BytesRead   uint64        `json:"bytes_read"`
+	BytesNew    uint64        `json:"bytes_new"`
+	BytesStored uint64        `json:"bytes_stored"`
+	Chunks      uint          `json:"chunks"`
+	ChunksNew   uint          `json:"chunks_new"`
+	Duration    time.Duration `json:"duration_ns"`
+}
+
+// Throughput returns the bytes read per second.
+func (stats WriteStats) Throughput() float64 {
+	if stats.Duration <= 0 {
+		return 0
+	}
+
+	return float64(stats.BytesRead) / stats.Duration.Seconds()
+}
+
+// CompressionRatio returns new bytes per stored byte, zero when nothing was
+// stored.
+func (stats WriteStats) CompressionRatio() float64 {
+	if stats.BytesStored == 0 {
+		return 0
+	}
+
+	return float64(stats.BytesNew) / float64(stats.BytesStored)
+}
+
+func (stats WriteStats) String() string {
+	return fmt.Sprintf(
+		"size: %s, writed: %s, stored: %s, chunks: %d, chunks writed: %d, duration: %s, %s/s",
+		humanize.Bytes(stats.BytesRead), humanize.Bytes(stats.BytesNew), humanize.Bytes(stats.BytesStored),
+		stats.Chunks, stats.ChunksNew, stats.Duration.Round(time.Millisecond), humanize.Bytes(uint64(stats.Throughput())),
+	)
+}
+
+// writeStats accumulates the stats of a running write and reports them to
+// the progress func.
+type writeStats struct {
+	WriteStats
+
+	started  time.Time
+	progress func(WriteStats)
+}
+
+func newWriteStats(progress func(WriteStats)) *writeStats {
+	return &writeStats{
+		started:  time.Now(),
+		progress: progress,
+	}
+}
+
+// addChunk counts a chunk of the input, stored is the size of a new chunk
+// in the repository.
+func (stats *writeStats) addChunk(length uint64, isWrited bool, stored int) {
+	stats.BytesRead += length
+	stats.Chunks++
+
+	if isWrited {
+		stats.ChunksNew++
+		stats.BytesNew += length
+		stats.BytesStored += uint64(stored)
+	}
+
+	stats.report()
+}
+
+// addMetadata counts a new chunk not part of the input, e.g. a tree.
+func (stats *writeStats) addMetadata(length uint64, stored int) {
+	stats.BytesNew += length
+	stats.BytesStored += uint64(stored)
+}
+
+func (stats *writeStats) report() {
+	if stats.progress != nil {
+		stats.progress(stats.snapshot())
+	}
+}
+
+func (stats *writeStats) snapshot() WriteStats {
+	result := stats.WriteStats
+
+	if !stats.started.IsZero() {
+		result.Duration = time.Since(stats.started)
+	}
+
+	return result
+}
+
+type WriteOptions struct {
+	// Source names the input in the block and its checkpoints, e.g. the
+	// file path.
+	Source string
+	// CheckpointInterval is the time between partial manifests written
+	// during the backup, zero disables checkpoints.
+	CheckpointInterval time.Duration
+	// Resume continues the checkpoint with this block ID, the reader must
+	// be an io.ReadSeeker over the same input.
+	Resume string
+	// Reader wraps the input once the resume position is found, e.g. for
+	// progress reporting.
+	Reader func(io.Reader) io.Reader
+	// Archive parses the input as tar or cpio archive, the content of every
+	// member is chunked on its own and the members are recorded in the
+	// block.
+	Archive string
+	// Progress is called with the current stats after every chunk.
+	Progress func(WriteStats)
+}
+
+// stream is the state of a stream being written. Resumed streams start
+// with the verified blobs of their checkpoint.
+type stream struct {
+	blobs    []Blob
+	size     uint64
+	checksum hash.Hash
+
+	// checkpoint is called with the contiguous blobs written so far
+	checkpoint     func(blobs []Blob) error
+	interval       time.Duration
+	lastCheckpoint time.Time
+}
+
+func (storage *Storage) newStream() *stream {
+	return &stream{
+		checksum:       storage.hasher.new(),
+		lastCheckpoint: time.Now(),
+	}
+}
+
+// maybeCheckpoint calls the checkpoint func once the interval has passed.
+func (st *stream) maybeCheckpoint() error {
+	if st.checkpoint == nil || time.Since(st.lastCheckpoint) < st.interval {
+		return nil
+	}
 
-func (storage *Storage) Writer(reader io.Reader) string {
-	buf := make([]byte, 4*chunker.MaxSize)
+	st.lastCheckpoint = time.Now()
 
-	fileChunker := chunker.NewWithBoundaries(reader, storage.pol, chunkerMinSize, chunker.MaxSize)
+	return st.checkpoint(st.blobs)
+}
 
-	var writed uint
-	var chunks uint
-	var chunksWrited uint
+func (storage *Storage) Writer(reader io.Reader, opts WriteOptions) (*WriteStats, error) {
+	stats := newWriteStats(opts.Progress)
+
+	st := storage.newStream()
 
 	block := NewBlock()
 
-	checksum, err := highwayhash.New(highwayhashKey)
-	if err != nil {
-		log.Fatal(err)
+	if len(opts.Resume) > 0 {
+		seeker, ok := reader.(io.ReadSeeker)
+		if !ok {
+			return nil, errors.New("resume requires a seekable input")
+		}
+
+		checkpoint, err := storage.GetCheckpoint(opts.Resume)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(checkpoint.Format) > 0 || len(opts.Archive) > 0 {
+			return nil, errors.New("archive backups can not be resumed")
+		}
+
+		if err := storage.resumeStream(seeker, checkpoint, st); err != nil {
+			return nil, err
+		}
+
+		block = &checkpoint.Block
+
+		log.Printf("resuming %s at %s", block.ID, humanize.Bytes(st.size))
+	}
+
+	block.ChunkHash = storage.config.ChunkHash
+	block.Source = opts.Source
+
+	if len(opts.Archive) > 0 {
+		if err := validateArchiveFormat(opts.Archive); err != nil {
+			return nil, err
+		}
+
+		block.Format = opts.Archive
+	}
+
+	if !storage.discard && opts.CheckpointInterval > 0 {
+		checkpoint := &Checkpoint{
+			Block: *block,
+		}
+
+		st.interval = opts.CheckpointInterval
+		st.checkpoint = func(blobs []Blob) error {
+			// chunks must be indexed before a manifest refers to them
+			if err := storage.flushIndex(); err != nil {
+				return err
+			}
+
+			blobs = storage.indexedPrefix(blobs)
+
+			checkpoint.Blobs = blobs
+			checkpoint.Size = 0
+
+			if len(blobs) > 0 {
+				last := blobs[len(blobs)-1]
+				checkpoint.Size = uint64(last.Offset + last.Length)
+			}
+			checkpoint.Updated = time.Now().UTC().Unix()
+
+			return storage.writeCheckpoint(checkpoint)
+		}
+	}
+
+	reader = storage.readLimit.Reader(reader)
+
+	if opts.Reader != nil {
+		reader = opts.Reader(reader)
+	}
+
+	if len(opts.Archive) > 0 {
+		members, err := storage.writeArchive(st, reader, opts.Archive, stats)
+		if err != nil {
+			return nil, err
+		}
+
+		block.Members = members
+	} else if err := storage.writeStreamTo(st, reader, nil, stats); err != nil {
+		return nil, err
+	}
+
+	block.Blobs = st.blobs
+	block.Size = st.size
+	block.CheckSum = hex.EncodeToString(st.checksum.Sum(nil))
+
+	if err := storage.flushIndex(); err != nil {
+		return nil, err
+	}
+
+	if err := storage.writeBlock(block); err != nil {
+		return nil, err
+	}
+
+	if !storage.discard && (st.checkpoint != nil || len(opts.Resume) > 0) {
+		if err := storage.DeleteCheckpoint(block.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	result := stats.snapshot()
+	result.BlockID = block.ID
+
+	return &result, nil
+}
+
+// writeStream chunks the reader and stores every chunk, returning the blob
+// list, the stream size and its checksum.
+func (storage *Storage) writeStream(reader io.Reader, buf []byte, stats *writeStats) ([]Blob, uint64, string, error) {
+	st := storage.newStream()
+
+	reader = storage.readLimit.Reader(reader)
+
+	if err := storage.writeStreamTo(st, reader, buf, stats); err != nil {
+		return nil, 0, "", err
+	}
+
+	return st.blobs, st.size, hex.EncodeToString(st.checksum.Sum(nil)), nil
+}
+
+// writeStreamTo appends the chunks of reader to the stream.
+func (storage *Storage) writeStreamTo(st *stream, reader io.Reader, buf []byte, stats *writeStats) error {
+	if buf == nil {
+		buf = make([]byte, 4*storage.config.Chunker.MaxSize)
+	}
+
+	if storage.workers > 1 {
+		return storage.writeStreamParallel(st, reader, buf, stats)
 	}
 
+	fileChunker := storage.newChunker(reader)
+
+	base := st.size
+
 	for {
 		chunk, err := fileChunker.Next(buf)
 		if err == io.EOF {
@@ -38,40 +303,30 @@ func (storage *Storage) Writer(reader io.Reader) string {
 		}
 
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		isWrited, chunkID := storage.writeChunk(chunk.Data)
-
-		if _, err := checksum.Write(chunk.Data); err != nil {
-			log.Fatal(err)
+		isWrited, chunkID, stored, err := storage.writeChunk(chunk.Data)
+		if err != nil {
+			return err
 		}
 
-		block.WriteBlob(Blob{
+		st.checksum.Write(chunk.Data)
+
+		st.blobs = append(st.blobs, Blob{
 			ID:     chunkID,
-			Offset: chunk.Start,
+			Offset: uint(base) + chunk.Start,
 			Length: chunk.Length,
 		})
 
-		block.Size += uint64(chunk.Length)
+		st.size += uint64(chunk.Length)
 
-		chunks++
+		stats.addChunk(uint64(chunk.Length), isWrited, stored)
 
-		if isWrited {
-			chunksWrited++
-			writed += chunk.Length
+		if err := st.maybeCheckpoint(); err != nil {
+			return err
 		}
 	}
 
-	block.CheckSum = hex.EncodeToString(checksum.Sum(nil))
-
-	if err := storage.writeBlock(block); err != nil {
-		log.Fatal(err)
-	}
-
-	return fmt.Sprintf(
-		"size: %s, writed: %s, chunks: %d, chunks writed: %d\nBlock ID: %s\n",
-		humanize.Bytes(block.Size), humanize.Bytes(uint64(writed)), chunks, chunksWrited,
-		block.ID,
-	)
+	return nil
 }
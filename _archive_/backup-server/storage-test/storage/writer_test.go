@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+const benchmarkStreamSize = 64 << 20 // 64 MB
+
+func newBenchmarkStream(b *testing.B) []byte {
+	b.Helper()
+
+	data := make([]byte, benchmarkStreamSize)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	return data
+}
+
+func benchmarkWriter(b *testing.B, workers int) {
+	data := newBenchmarkStream(b)
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+
+		store, err := New(StorageConfig{
+			Path:     b.TempDir(),
+			Password: "benchmark",
+			Workers:  workers,
+			Create:   true,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StartTimer()
+
+		var stats writeStats
+
+		if _, _, _, err := store.writeStream(bytes.NewReader(data), nil, &stats); err != nil {
+			b.Fatal(err)
+		}
+
+		if err := store.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriterSequential(b *testing.B) {
+	benchmarkWriter(b, 1)
+}
+
+func BenchmarkWriterParallel(b *testing.B) {
+	benchmarkWriter(b, 4)
+}
+
+func BenchmarkWriterParallelDedup(b *testing.B) {
+	data := newBenchmarkStream(b)
+
+	store, err := New(StorageConfig{
+		Path:     b.TempDir(),
+		Password: "benchmark",
+		Create:   true,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer store.Close()
+
+	var stats writeStats
+
+	if _, _, _, err := store.writeStream(bytes.NewReader(data), nil, &stats); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := store.writeStream(bytes.NewReader(data), nil, &stats); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
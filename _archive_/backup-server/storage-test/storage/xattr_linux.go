@@ -0,0 +1,49 @@
+//go:build linux
+
+package storage
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+func readXattrs(name string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(name, nil)
+	if err != nil || size == 0 {
+		if err == unix.ENOTSUP {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+
+	if size, err = unix.Llistxattr(name, buf); err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string][]byte)
+
+	for _, attr := range bytes.Split(buf[:size], []byte{0}) {
+		if len(attr) == 0 {
+			continue
+		}
+
+		valueSize, err := unix.Lgetxattr(name, string(attr), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, valueSize)
+
+		if valueSize, err = unix.Lgetxattr(name, string(attr), value); err != nil {
+			return nil, err
+		}
+
+		xattrs[string(attr)] = value[:valueSize]
+	}
+
+	return xattrs, nil
+}
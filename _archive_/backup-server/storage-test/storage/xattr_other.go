@@ -0,0 +1,7 @@
+//go:build !linux
+
+package storage
+
+func readXattrs(name string) (map[string][]byte, error) {
+	return nil, nil
+}